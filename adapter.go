@@ -1,10 +1,12 @@
 package watcher
 
 import (
+	"context"
 	"crypto/md5"
 	"crypto/sha1"
 	"crypto/sha256"
 	"crypto/sha512"
+	"errors"
 	"fmt"
 	"github.com/bytedance/sonic"
 	bolt "go.etcd.io/bbolt"
@@ -16,55 +18,171 @@ import (
 	"os"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
+// Adapter is safe for concurrent use, the scans and the saves of a root path are serialized.
 type Adapter struct {
 	hashAlgorithm string
-	hash          hash.Hash
+	newHash       func() hash.Hash
 	hashingDB     *bolt.DB
 
-	fileList map[string]FileInfos
-	settings map[string]*adapterSetting
+	// mu guards the maps, the retentions and the reporter, rootLocks serializes the uses of the db of a root path,
+	// hashingMu and journalMu serialize the uses of the hashing db and the journal db
+	mu        sync.RWMutex
+	rootLocks sync.Map
+	hashingMu sync.Mutex
+	journalMu sync.Mutex
+
+	fileList         map[string]FileInfos // the saved file lists read by the in-memory Compare and Save
+	settings         map[string]*adapterSetting
+	retention        SnapshotRetention
+	journalRetention JournalRetention
+	errorPolicies    map[ErrorClass]ErrorPolicy
+	progress         ProgressReporter
+	fullScanAt       map[string]time.Time
+	incomplete       map[string]bool     // the root paths whose last scan is unfinished
+	mountPoints      map[string][]string // the mount points found in the last scan of the root paths
 }
 
 type adapterSetting struct {
-	RootPath      string    `yaml:"root_path" json:"root_path"`
-	At            time.Time `yaml:"at" json:"at"`
-	HashAlgorithm string    `yaml:"hash_algorithm" json:"hash_algorithm"`
-	Stats         fileStats `yaml:"stats" json:"stats"`
+	RootPath      string       `yaml:"root_path" json:"root_path"`
+	At            time.Time    `yaml:"at" json:"at"`
+	HashAlgorithm string       `yaml:"hash_algorithm" json:"hash_algorithm"`
+	Stats         fileStats    `yaml:"stats" json:"stats"`
+	Generation    uint64       `yaml:"generation" json:"generation"`     // the active file list, 0 for the legacy one
+	Schema        int          `yaml:"schema" json:"schema"`             // the schema version of the values
+	FullScanAt    time.Time    `yaml:"full_scan_at" json:"full_scan_at"` // the last full walk in the fast-scan mode
+	MountPoints   []string     `yaml:"mount_points" json:"mount_points,omitempty"`
+	Suspect       *rootSuspect `yaml:"suspect" json:"suspect,omitempty"` // the changes are refused to save by the deletion guard
 }
 
 const DBFile = ".watch.db"
 const hashingDbFile = "hashing.db"
 
+// maxIncrementalEntries is the max count of changes applied to the saved file list in one transaction,
+// the whole file list is rewritten if there are more changes
+const maxIncrementalEntries = 100000
+
 func NewAdapter(hashAlgorithm string) *Adapter {
 	_ = sonic.Pretouch(reflect.TypeOf(&FileInfo{}))
 
-	var h hash.Hash
+	var h func() hash.Hash
 
 	switch strings.ToLower(hashAlgorithm) {
 	case "md5":
-		h = md5.New()
+		h = md5.New
 	case "sha1":
-		h = sha1.New()
+		h = sha1.New
 	case "sha256":
-		h = sha256.New()
+		h = sha256.New
 	case "sha512":
-		h = sha512.New()
+		h = sha512.New
 	case "crc32":
-		h = crc32.NewIEEE()
+		h = func() hash.Hash { return crc32.NewIEEE() }
 	default:
-		h = md5.New()
+		h = md5.New
 	}
 
 	return &Adapter{
-		hash:          h,
+		newHash:       h,
 		hashAlgorithm: hashAlgorithm,
 		fileList:      make(map[string]FileInfos),
 		settings:      make(map[string]*adapterSetting),
+		errorPolicies: make(map[ErrorClass]ErrorPolicy),
+		fullScanAt:    make(map[string]time.Time),
+		incomplete:    make(map[string]bool),
+		mountPoints:   make(map[string][]string),
+	}
+}
+
+// SetErrorPolicy sets the policy of the errors of the class, the default policy is Continue
+func (s *Adapter) SetErrorPolicy(class ErrorClass, policy ErrorPolicy) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.errorPolicies[class] = policy
+}
+
+// appendError appends the error to errs, returns true if the policy of its class is Abort
+func (s *Adapter) appendError(errs *error, err *Error) bool {
+	*errs = multierr.Append(*errs, err)
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.errorPolicies[err.Class] == Abort
+}
+
+// aborted returns true if any of the errors should abort watching
+func (s *Adapter) aborted(errs error) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for _, err := range multierr.Errors(errs) {
+		var e *Error
+		if errors.As(err, &e) && s.errorPolicies[e.Class] == Abort {
+			return true
+		}
+	}
+	return false
+}
+
+// forget drops the loaded states of the root path, such as the root path is removed with its db
+func (s *Adapter) forget(rootPath string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.settings, formatPath(rootPath))
+	delete(s.fileList, formatPath(rootPath))
+	delete(s.fullScanAt, formatPath(rootPath))
+	delete(s.incomplete, formatPath(rootPath))
+	delete(s.mountPoints, formatPath(rootPath))
+}
+
+// lockRoot locks the db of the root path, it returns the unlock function
+func (s *Adapter) lockRoot(rootPath string) func() {
+	mu, _ := s.rootLocks.LoadOrStore(formatPath(rootPath), &sync.Mutex{})
+	mu.(*sync.Mutex).Lock()
+	return mu.(*sync.Mutex).Unlock
+}
+
+// setting returns the loaded setting of the root path, nil if it's never saved
+func (s *Adapter) setting(rootPath string) *adapterSetting {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.settings[formatPath(rootPath)]
+}
+
+// loaded returns true if the root path is loaded, though it's never saved
+func (s *Adapter) loaded(rootPath string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	_, ok := s.settings[formatPath(rootPath)]
+	return ok
+}
+
+// lastFullScan returns the time of the last full walk of the root path in the fast-scan mode
+func (s *Adapter) lastFullScan(rootPath string) time.Time {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.fullScanAt[formatPath(rootPath)]
+}
+
+// savedMountPoints returns the mount points found in the last scan of the root path
+func (s *Adapter) savedMountPoints(rootPath string) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.mountPoints[formatPath(rootPath)]
+}
+
+// setScanned records the walk of the root path, they are saved with its file list
+func (s *Adapter) setScanned(rootPath string, fullScan bool, mountPoints []string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if fullScan {
+		s.fullScanAt[formatPath(rootPath)] = time.Now()
 	}
+	s.mountPoints[formatPath(rootPath)] = mountPoints
 }
 
 // get the db path of root path
@@ -81,40 +199,139 @@ func (s *Adapter) LoadAll(rootPaths ...string) error {
 	return err
 }
 
-// load the history file list
+// load the setting of the root path, the saved file list is not read until it's used.
+// A missing root path is skipped, it's loaded when it appears.
 func (s *Adapter) load(rootPath string) error {
+	defer s.lockRoot(rootPath)()
+
+	s.forget(rootPath)
+	if _, err := os.Stat(rootPath); os.IsNotExist(err) {
+		return nil
+	}
+
 	db, err := s.openDB(s.getDbPath(rootPath))
 	if err != nil {
 		return err
 	}
 	defer db.Close()
 
-	s.settings[formatPath(rootPath)] = s.readSetting(db, s.pathKey(rootPath))
-	s.fileList[formatPath(rootPath)] = s.readAllFileInfos(db, s.pathKey(rootPath))
+	return s.loadDB(db, rootPath)
+}
 
-	log.Printf("Loaded file list from db: %s", s.getDbPath(rootPath))
+// loadDB loads the setting of the root path from its opened db, the root path must be locked
+func (s *Adapter) loadDB(db *bolt.DB, rootPath string) error {
+	if err := s.recover(db, s.pathKey(rootPath)); err != nil {
+		return fmt.Errorf("recovering db \"%s\" error, run the \"repair\" command if any entry could not be read: %w", s.getDbPath(rootPath), err)
+	}
+
+	setting, err := s.readSetting(db, s.pathKey(rootPath))
+	if err != nil {
+		return fmt.Errorf("loading db \"%s\" error, run the \"repair\" command to remove the bad entries: %w", s.getDbPath(rootPath), err)
+	}
+	if setting != nil && setting.Schema < schemaVersion {
+		if err = s.migrate(db, s.pathKey(rootPath), setting); err != nil {
+			return fmt.Errorf("migrating db \"%s\" error, run the \"repair\" command if any entry could not be read: %w", s.getDbPath(rootPath), err)
+		}
+		log.Printf("Migrated db \"%s\" to schema %d", s.getDbPath(rootPath), schemaVersion)
+	}
+
+	meta, err := s.readCheckpointMeta(db, s.pathKey(rootPath))
+	if err != nil {
+		log.Printf("[WARN] the checkpoint of \"%s\" could not be read: %s", s.getDbPath(rootPath), err)
+	}
+
+	s.mu.Lock()
+	s.settings[formatPath(rootPath)] = setting
+	if setting != nil {
+		s.fullScanAt[formatPath(rootPath)] = setting.FullScanAt
+		s.mountPoints[formatPath(rootPath)] = setting.MountPoints
+	}
+	delete(s.fileList, formatPath(rootPath))
+	s.incomplete[formatPath(rootPath)] = meta != nil
+	s.mu.Unlock()
+	if meta != nil {
+		log.Printf("The last scan of \"%s\" is incomplete, it started at %s and walked %d directories, resuming it",
+			rootPath, meta.StartedAt.Format(time.RFC3339), meta.Dirs)
+	}
+
+	log.Printf("Loaded db: %s", s.getDbPath(rootPath))
 
 	return nil
 }
 
+// savedFileInfos returns the saved file list of the root path for the in-memory Compare and Save,
+// it's read on the first use and kept in memory. The streaming scan of Watcher never reads the whole file list.
+func (s *Adapter) savedFileInfos(db *bolt.DB, rootPath string) FileInfos {
+	s.mu.RLock()
+	fileInfos := s.fileList[formatPath(rootPath)]
+	s.mu.RUnlock()
+	if fileInfos != nil {
+		return fileInfos
+	}
+
+	fileInfos, err := s.readAllFileInfos(db, s.pathKey(rootPath))
+	if err != nil {
+		// the undecodable entries are treated as not existing, they will be reported as created files
+		log.Printf("[WARN] %d entries of \"%s\" could not be read, run the \"repair\" command to remove them: %s",
+			len(multierr.Errors(err)), s.getDbPath(rootPath), err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fileList[formatPath(rootPath)] = fileInfos
+	return fileInfos
+}
+
 func (s *Adapter) pathKey(path string) []byte {
 	return []byte(formatPath(path))
 }
 
+// Compare compares the current file list with the saved one of the root path in memory, Watcher uses the streaming scan instead.
+// The files whose mode is changed are updated, they are recorded as Chmod in the snapshot.
+// The errors are returned as *Error, the hash-sum of the failed file is nil if the policy is Continue.
 func (s *Adapter) Compare(rootPath string, currentFiles FileInfos) (
 	created,
 	updated,
 	deleted,
 	moved,
 	renamed FileInfos,
+	err error,
 ) {
+	return s.CompareContext(context.Background(), rootPath, currentFiles)
+}
+
+// CompareContext is Compare which stops hashing on the cancellation of ctx and returns ctx.Err(),
+// the hashed files are kept in the hashing db for the next Compare
+func (s *Adapter) CompareContext(ctx context.Context, rootPath string, currentFiles FileInfos) (
+	created,
+	updated,
+	deleted,
+	moved,
+	renamed FileInfos,
+	err error,
+) {
+
+	unlock := s.lockRoot(rootPath)
+	db, dbErr := s.openDB(s.getDbPath(rootPath))
+	if dbErr != nil {
+		unlock()
+		err = newError(ErrorClassDB, rootPath, s.getDbPath(rootPath), "open db", dbErr)
+		return
+	}
+	oldFileInfos := s.savedFileInfos(db, rootPath)
+	_ = db.Close()
+	unlock()
 
 	// compare the current file list with the old file list, and stats the created, updated, deleted files
 	// **AND** sets the old hash sum to currentFiles for not changed files
-	created, updated, deleted = s.compareCUD(rootPath, currentFiles)
+	created, updated, deleted = s.compareCUD(oldFileInfos, currentFiles)
 
 	// hashing the created, updated
-	s.hashing(rootPath, NewFileInfos().Append(created, updated))
+	if err = s.hashing(ctx, rootPath, NewFileInfos().Append(created, updated)); ctx.Err() != nil {
+		return nil, nil, nil, nil, nil, ctx.Err()
+	} else if s.aborted(err) {
+		return
+	}
 
 	// stats moved or renamed files from deleted && created files
 	// it'll remove the moved or renamed files from deleted && created files
@@ -123,7 +340,9 @@ func (s *Adapter) Compare(rootPath string, currentFiles FileInfos) (
 	return
 }
 
-func (s *Adapter) hashing(rootPath string, fileInfos FileInfos) {
+// hashing hashes the regular files, it stops on the cancellation of ctx and returns ctx.Err() with the other errors,
+// the hashed files are kept in the hashing db
+func (s *Adapter) hashing(ctx context.Context, rootPath string, fileInfos FileInfos) (errs error) {
 	var currentSize int64
 
 	stats := fileInfos.stats()
@@ -132,72 +351,96 @@ func (s *Adapter) hashing(rootPath string, fileInfos FileInfos) {
 		return
 	}
 
+	s.hashingMu.Lock()
+	defer s.hashingMu.Unlock()
+
+	// the hashing db is a cache, hashing works without it
 	db, err := s.openHashingDB()
 	if err != nil {
-		log.Printf("\n[ERROR] open hashing db error: %s\n", err)
+		if s.appendError(&errs, newError(ErrorClassDB, rootPath, s.dataDBPath(hashingDbFile), "open hashing db", err)) {
+			return
+		}
 	} else {
 		defer db.Close()
 	}
 
-	historyFileInfos := s.readFileInfos(db, s.pathKey(rootPath), fileInfos.Keys())
+	progress := s.progressReporter()
+	historyFileInfos, err := s.readFileInfos(db, s.pathKey(rootPath), fileInfos.Keys())
+	if err != nil {
+		// the undecodable entries are hashed again
+		log.Printf("[WARN] %d entries of \"%s\" could not be read, run the \"repair\" command to remove them: %s",
+			len(multierr.Errors(err)), s.dataDBPath(hashingDbFile), err)
+	}
 	hashingFileInfos := NewFileInfos()
+	flushedAt := time.Now()
 
+	// the hashed files are flushed every 100 files or checkpointInterval, an interrupted hashing resumes from them
 	var putToHashingDB = func(path string, info *FileInfo) {
 		if path != "" {
 			hashingFileInfos.Put(path, info)
 		}
 
-		if path == "" || hashingFileInfos.Len() >= 100 {
-			_ = s.putFileInfos(db, s.pathKey(rootPath), hashingFileInfos, false)
+		if path == "" || hashingFileInfos.Len() >= 100 || time.Since(flushedAt) >= checkpointInterval {
+			flushedAt = time.Now()
+			if err := s.putFileInfos(db, hashingFileInfos, s.pathKey(rootPath)); err != nil {
+				s.appendError(&errs, newError(ErrorClassDB, rootPath, s.dataDBPath(hashingDbFile), "save hashing db", err))
+			}
 			hashingFileInfos = NewFileInfos()
 		}
 	}
 
-	// hash sum for created and updated files
+	// hash sum for created and updated files, only the regular files have contents,
+	// the targets of the links are tracked by the symlink policy
 	for _, currentFile := range fileInfos {
-		if !currentFile.IsDir() {
+		if err := ctx.Err(); err != nil {
+			putToHashingDB("", nil)
+			return multierr.Append(errs, err)
+		}
+
+		if currentFile.Mode().IsRegular() {
 			path := currentFile.Path()
 
 			// read hash-sum from history file if exists
 			if historyFileInfo, ok := historyFileInfos.Get(path); ok {
 				if historyFileInfo.Mode() == currentFile.Mode() &&
 					historyFileInfo.ModTime() == currentFile.ModTime() &&
-					historyFileInfo.FileSize == currentFile.FileSize {
+					historyFileInfo.FileSize == currentFile.FileSize &&
+					historyFileInfo.LinkTarget == currentFile.LinkTarget {
 					currentFile.FileHashSum = historyFileInfo.FileHashSum
 				}
 			}
 
 			// hash-sum
 			if len(currentFile.FileHashSum) == 0 {
-				currentFile.FileHashSum, err = s.hashSum(path)
-				if err != nil {
-					log.Printf("\n[ERROR] hashing file error: %s\n", err)
+				currentFile.FileHashSum, err = s.hashSum(ctx, path)
+				if ctxErr := ctx.Err(); ctxErr != nil {
+					currentFile.FileHashSum = nil
+					putToHashingDB("", nil)
+					return multierr.Append(errs, ctxErr)
+				} else if err != nil {
+					if s.appendError(&errs, newError(ErrorClassHash, rootPath, path, "hash", err)) {
+						putToHashingDB("", nil)
+						return
+					}
+				} else {
+					putToHashingDB(path, currentFile)
 				}
-
-				putToHashingDB(path, currentFile)
 			}
 
 			currentSize += currentFile.FileSize
-
-			fmt.Printf("\rHashing \"%s\": progress: %0.2f%% files: %s/%s", rootPath, float64(currentSize)/float64(stats.TotalSize)*100, ByteCountIEC(currentSize), ByteCountIEC(stats.TotalSize))
-
+			progress.BytesHashed(rootPath, currentSize, stats.TotalSize)
 		}
 	}
 
 	putToHashingDB("", nil)
-
-	fmt.Println()
+	return
 }
 
-// compareCUD compares the current file list with the old file list, and stats the created, updated, deleted files
-// **AND** sets the old hash sum to currentFiles for not changed files
-func (s *Adapter) compareCUD(rootPath string, currentFileInfos FileInfos) (created, updated, deleted FileInfos) {
-	var oldFileInfos FileInfos
+// compareCUD compares the current file list with the old file list, and stats the created, updated, deleted files,
+// the files whose mode is changed are updated **AND** sets the old hash sum to currentFiles for not changed contents
+func (s *Adapter) compareCUD(oldFileInfos, currentFileInfos FileInfos) (created, updated, deleted FileInfos) {
 	var ok bool
 	var path string
-	if oldFileInfos, ok = s.fileList[formatPath(rootPath)]; !ok || oldFileInfos == nil {
-		oldFileInfos = NewFileInfos()
-	}
 
 	created = make(FileInfos)
 	updated = make(FileInfos)
@@ -213,12 +456,15 @@ func (s *Adapter) compareCUD(rootPath string, currentFileInfos FileInfos) (creat
 			continue
 		}
 
-		if !currentFile.FileMtime.Equal(oldFile.FileMtime) || currentFile.FileSize != oldFile.FileSize {
+		switch currentFile.changeOf(oldFile) {
+		case Write:
 			updated.Put(path, currentFile)
 			continue
+		case Chmod:
+			updated.Put(path, currentFile)
 		}
 
-		// if the file is not changed, use the old hash sum
+		// if the content is not changed, use the old hash sum
 		currentFile.FileHashSum = oldFile.FileHashSum
 	}
 
@@ -251,58 +497,193 @@ func (s *Adapter) compareMv(deleted, created FileInfos) (moved, renamed FileInfo
 
 				deleted.Delete(deletedPath)
 				created.Delete(createdPath)
+				// a deleted file is moved to one created file at most
+				break
 			}
 		}
 	}
 	return
 }
 
-func (s *Adapter) hashSum(path string) ([]byte, error) {
-	s.hash.Reset()
+func (s *Adapter) hashSum(ctx context.Context, path string) ([]byte, error) {
 	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
-	if _, err = io.Copy(s.hash, file); err != nil {
+	h := s.newHash()
+	if _, err = io.Copy(h, &contextReader{ctx: ctx, r: file}); err != nil {
 		return nil, err
 	}
 
-	return s.hash.Sum(nil), nil
+	return h.Sum(nil), nil
 }
 
-func (s *Adapter) SaveAll() {
+// contextReader stops reading on the cancellation of the context, so a huge file is not read to the end
+type contextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (r *contextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return r.r.Read(p)
+}
+
+func (s *Adapter) SaveAll() error {
+	s.mu.RLock()
+	fileList := make(map[string]FileInfos, len(s.fileList))
 	for rootPath, currentFiles := range s.fileList {
-		s.Save(rootPath, currentFiles)
+		fileList[rootPath] = currentFiles
+	}
+	s.mu.RUnlock()
+
+	var err error
+	for rootPath, currentFiles := range fileList {
+		err = multierr.Append(err, s.Save(rootPath, currentFiles))
 	}
+	return err
 }
 
-func (s *Adapter) Save(rootPath string, fileInfos FileInfos) {
-	dbPath := s.getDbPath(rootPath)
-	db, err := s.openDB(dbPath)
+// Save saves the file list of the root path and records the snapshot, the errors are returned as *Error.
+// The file list is merged with the saved one in the order of the keys as the scan of Watcher does, only the changes are written.
+func (s *Adapter) Save(rootPath string, fileInfos FileInfos) error {
+	return s.SaveContext(context.Background(), rootPath, fileInfos)
+}
+
+// SaveContext is Save which stops on the cancellation of ctx and returns ctx.Err(), the saved file list is not changed then
+func (s *Adapter) SaveContext(ctx context.Context, rootPath string, fileInfos FileInfos) error {
+	sc, err := s.beginScan(rootPath)
 	if err != nil {
-		log.Printf("[ERROR] opening database \"%s\" error: %s\n", dbPath, err)
+		return newError(ErrorClassDB, rootPath, s.getDbPath(rootPath), "open db", err)
+	}
+	defer sc.close()
+	// the progress is reported by saving, the files are not walked
+	sc.progress = NopProgressReporter{}
+
+	// merge the files with the saved file list as the streaming scan does, the saved file list is not read into memory
+	infos := fileInfos.Values()
+	sort.Slice(infos, func(i, j int) bool {
+		return formatPath(infos[i].Path()) < formatPath(infos[j].Path())
+	})
+	for _, info := range infos {
+		if err = sc.add(info); err != nil {
+			return err
+		}
+	}
+	if err = sc.finish(); err != nil {
+		return newError(ErrorClassDB, rootPath, s.getDbPath(rootPath), "read file list", err)
+	}
+
+	moved, renamed := s.compareMv(sc.deleted, sc.created)
+	if err = sc.commit(ctx, sc.created, sc.updated, sc.deleted, moved, renamed); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.fileList[formatPath(rootPath)] = fileInfos
+	return nil
+}
+
+// saveChanges applies the entries of the snapshot to the saved file list of the root path and records the snapshot,
+// the errors are returned as *Error.
+// The entries are applied to the active file list in place if they are few, otherwise a new generation is written
+// by merging them with the active one, it's not active until the setting is swapped.
+func (s *Adapter) saveChanges(ctx context.Context, db *bolt.DB, rootPath string, entries map[string]*snapshotEntry, snapshot *Snapshot) error {
+	dbPath := s.getDbPath(rootPath)
+
+	s.mu.RLock()
+	oldSetting := s.settings[formatPath(rootPath)]
+	fullScanAt, mountPoints := s.fullScanAt[formatPath(rootPath)], s.mountPoints[formatPath(rootPath)]
+	s.mu.RUnlock()
+
+	var generation uint64
+	var schema = schemaVersion
+	if oldSetting != nil {
+		generation = oldSetting.Generation
+		schema = oldSetting.Schema
 	}
-	defer db.Close()
 
 	setting := &adapterSetting{
 		RootPath:      rootPath,
 		At:            time.Now(),
 		HashAlgorithm: s.hashAlgorithm,
-		Stats:         fileInfos.stats(),
+		Stats:         snapshot.Stats,
+		Generation:    generation,
+		Schema:        schema,
+		FullScanAt:    fullScanAt,
+		MountPoints:   mountPoints,
 	}
+	snapshot.At = setting.At
+	snapshot.Initial = oldSetting == nil
 
-	s.settings[formatPath(rootPath)] = setting
-	s.fileList[formatPath(rootPath)] = fileInfos
+	var err error
+	incremental := !snapshot.Initial && len(entries) <= maxIncrementalEntries && int64(len(entries)) <= setting.Stats.count()/2
+	if !incremental {
+		if setting.Generation, err = s.nextGeneration(db, s.pathKey(rootPath)); err != nil {
+			return newError(ErrorClassDB, rootPath, dbPath, "save file list", err)
+		}
 
-	if err = s.putSetting(db, s.pathKey(rootPath), setting); err != nil {
-		log.Printf("[ERROR] saving setting to \"%s\" error: %s\n", dbPath, err)
+		if err = s.writeGeneration(ctx, db, rootPath, generation, setting.Generation, entries, setting.Stats.count()); err != nil {
+			_ = db.Update(func(tx *bolt.Tx) error {
+				return s.deleteFileBucket(tx, s.pathKey(rootPath), setting.Generation)
+			})
+			return newError(ErrorClassDB, rootPath, dbPath, "save file list", err)
+		}
 	}
 
-	if err = s.putFileInfos(db, s.pathKey(rootPath), fileInfos, true); err != nil {
-		log.Printf("[ERROR] saving file informations to \"%s\" error: %s\n", dbPath, err)
+	// apply the changes or swap the file list, and record the snapshot in one transaction, it's not interrupted
+	if err = ctx.Err(); err != nil {
+		if !incremental {
+			_ = db.Update(func(tx *bolt.Tx) error {
+				return s.deleteFileBucket(tx, s.pathKey(rootPath), setting.Generation)
+			})
+		}
+		return newError(ErrorClassDB, rootPath, dbPath, "save snapshot", err)
+	}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		if incremental {
+			if err := s.applySnapshotEntries(tx, s.fileBucketNames(s.pathKey(rootPath), generation), entries); err != nil {
+				return err
+			}
+		}
+		if err := s.putSnapshot(tx, s.pathKey(rootPath), snapshot, entries); err != nil {
+			return err
+		}
+		if err := s.putSettingTx(tx, s.pathKey(rootPath), setting); err != nil {
+			return err
+		}
+		// the scan is complete
+		if err := s.deleteCheckpoint(tx, s.pathKey(rootPath)); err != nil {
+			return err
+		}
+		if setting.Generation != generation {
+			if err := s.deleteFileBucket(tx, s.pathKey(rootPath), generation); err != nil {
+				return err
+			}
+		}
+		return s.pruneSnapshots(tx, s.pathKey(rootPath))
+	}); err != nil {
+		return newError(ErrorClassDB, rootPath, dbPath, "save snapshot", err)
 	}
 
-	log.Printf("Saved file informations to \"%s\"", dbPath)
+	s.mu.Lock()
+	s.settings[formatPath(rootPath)] = setting
+	// the in-memory file list is stale if it's not saved by Save
+	delete(s.fileList, formatPath(rootPath))
+	delete(s.incomplete, formatPath(rootPath))
+	s.mu.Unlock()
+
+	if incremental {
+		s.progressReporter().SaveProgress(rootPath, int64(len(entries)), int64(len(entries)))
+	}
+	log.Printf("Saved file informations to \"%s\", snapshot: %d", dbPath, snapshot.ID)
+	return nil
 }
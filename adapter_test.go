@@ -0,0 +1,156 @@
+package watcher
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChangeOf(t *testing.T) {
+	mtime := time.Now()
+	old := &FileInfo{FileSize: 1, FileMode: 0644, FileMtime: mtime}
+
+	tests := []struct {
+		name string
+		info FileInfo
+		want Op
+	}{
+		{"unchanged", FileInfo{FileSize: 1, FileMode: 0644, FileMtime: mtime}, 0},
+		{"size", FileInfo{FileSize: 2, FileMode: 0644, FileMtime: mtime}, Write},
+		{"mtime", FileInfo{FileSize: 1, FileMode: 0644, FileMtime: mtime.Add(time.Second)}, Write},
+		{"mode", FileInfo{FileSize: 1, FileMode: 0600, FileMtime: mtime}, Chmod},
+		{"mode and size", FileInfo{FileSize: 2, FileMode: 0600, FileMtime: mtime}, Write},
+		{"link target", FileInfo{FileSize: 1, FileMode: 0644, FileMtime: mtime, LinkTarget: "b"}, Write},
+	}
+	for _, tt := range tests {
+		if got := tt.info.changeOf(old); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+// the events, the snapshots, Compare and Save agree on a mode change
+func TestChmodAgrees(t *testing.T) {
+	rootPath := t.TempDir()
+	for i := 0; i < 10; i++ {
+		writeFile(t, filepath.Join(rootPath, fmt.Sprint(i)), "x")
+	}
+	adapter := NewAdapter("md5")
+	watchOnce(t, adapter, rootPath, testOption())
+
+	if err := os.Chmod(filepath.Join(rootPath, "1"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	infos := walkOnce(t, rootPath, testOption())
+	created, updated, deleted, _, _, err := adapter.Compare(rootPath, infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Len() != 0 || deleted.Len() != 0 || !reflect.DeepEqual(relNames(rootPath, updated), []string{"1"}) {
+		t.Fatalf("compare: created %v, updated %v, deleted %v", created.Keys(), updated.Keys(), deleted.Keys())
+	}
+
+	events := watchOnce(t, adapter, rootPath, testOption())
+	if len(events) != 1 || events[0].Op != Chmod || events[0].Path != filepath.Join(rootPath, "1") {
+		t.Fatalf("unexpected events: %v", events)
+	}
+
+	snapshots, err := adapter.Snapshots(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[1].Updated != 1 {
+		t.Fatalf("unexpected snapshots: %d", len(snapshots))
+	}
+	revisions, err := adapter.FileHistory(rootPath, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 2 || revisions[0].Op != Chmod {
+		t.Fatalf("unexpected revisions: %d", len(revisions))
+	}
+	_, updated, _, _, _, err = adapter.DiffSnapshots(rootPath, 1, 2)
+	if err != nil || !reflect.DeepEqual(relNames(rootPath, updated), []string{"1"}) {
+		t.Fatalf("diff: %v %v", updated.Keys(), err)
+	}
+
+	// Save records the same snapshot as the scan
+	if err = os.Chmod(filepath.Join(rootPath, "1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = adapter.Save(rootPath, walkOnce(t, rootPath, testOption())); err != nil {
+		t.Fatal(err)
+	}
+	if snapshots, err = adapter.Snapshots(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 || snapshots[2].Updated != 1 || snapshots[2].Created != 0 {
+		t.Fatalf("unexpected snapshot of Save: %+v", snapshots[len(snapshots)-1])
+	}
+	if revisions, _ = adapter.FileHistory(rootPath, "1"); len(revisions) != 3 || revisions[0].Op != Chmod {
+		t.Fatalf("unexpected revisions after Save: %d", len(revisions))
+	}
+}
+
+func TestSaveIncremental(t *testing.T) {
+	rootPath := t.TempDir()
+	for i := 0; i < 20; i++ {
+		writeFile(t, filepath.Join(rootPath, fmt.Sprintf("f%d.txt", i)), fmt.Sprint(i))
+	}
+	adapter := NewAdapter("md5")
+	infos := walkOnce(t, rootPath, testOption())
+	if _, _, _, _, _, err := adapter.Compare(rootPath, infos); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.Save(rootPath, infos); err != nil {
+		t.Fatal(err)
+	}
+	generation := adapter.setting(rootPath).Generation
+
+	time.Sleep(10 * time.Millisecond)
+	writeFile(t, filepath.Join(rootPath, "f1.txt"), "changed")
+	if err := os.Remove(filepath.Join(rootPath, "f2.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(rootPath, "f3.txt"), filepath.Join(rootPath, "g3.txt")); err != nil {
+		t.Fatal(err)
+	}
+
+	infos = walkOnce(t, rootPath, testOption())
+	_, updated, deleted, _, renamed, err := adapter.Compare(rootPath, infos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.Len() != 1 || deleted.Len() != 1 || renamed.Len() != 1 {
+		t.Fatalf("compare: updated %v, deleted %v, renamed %v", updated.Keys(), deleted.Keys(), renamed.Keys())
+	}
+	if err = adapter.Save(rootPath, infos); err != nil {
+		t.Fatal(err)
+	}
+
+	// the changes are applied to the active file list in place
+	if adapter.setting(rootPath).Generation != generation {
+		t.Error("the whole file list is rewritten")
+	}
+	snapshots, err := adapter.Snapshots(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	last := snapshots[len(snapshots)-1]
+	if last.Updated != 1 || last.Deleted != 1 || last.Renamed != 1 || last.Created != 0 {
+		t.Errorf("unexpected snapshot: %+v", last)
+	}
+
+	saved := savedFiles(t, adapter, rootPath)
+	if !reflect.DeepEqual(relNames(rootPath, saved), relNames(rootPath, infos)) {
+		t.Fatalf("saved %v, want %v", relNames(rootPath, saved), relNames(rootPath, infos))
+	}
+	for path, info := range infos {
+		if s := saved[path]; s == nil || s.changeOf(info) != 0 || len(s.FileHashSum) == 0 {
+			t.Errorf("%s is not saved with its hash-sum", path)
+		}
+	}
+}
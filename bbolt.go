@@ -1,20 +1,40 @@
 package watcher
 
 import (
+	"context"
 	"fmt"
 	"github.com/bytedance/sonic"
 	bolt "go.etcd.io/bbolt"
 	"go.uber.org/multierr"
+	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"time"
 )
 
+// fileBucket is the bucket of the saved file lists, file list of a root path is stored
+// in the nested bucket "file" -> root path -> generation, the generation in the setting is the active one.
+// The legacy file list (generation 0) is stored in the bucket of root path directly.
+const fileBucket = "file"
+const settingBucket = "setting"
+
 func (s *Adapter) openHashingDB() (*bolt.DB, error) {
+	return s.openDataDB(hashingDbFile)
+}
+
+// openDataDB opens the db file in the "data" directory beside the executable
+func (s *Adapter) openDataDB(file string) (*bolt.DB, error) {
+	path := s.dataDBPath(file)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	return s.openDB(path)
+}
+
+func (s *Adapter) dataDBPath(file string) string {
 	p, _ := os.Executable()
-	dir := filepath.Join(filepath.Dir(p), "data")
-	_ = os.MkdirAll(dir, 0)
-	return s.openDB(filepath.Join(dir, hashingDbFile))
+	return filepath.Join(filepath.Dir(p), "data", file)
 }
 
 // openDB
@@ -22,72 +42,99 @@ func (s *Adapter) openDB(path string) (*bolt.DB, error) {
 	return bolt.Open(path, 0665, &bolt.Options{Timeout: 5 * time.Second})
 }
 
-func (s *Adapter) readFileInfos(db *bolt.DB, bucketName []byte, keys []string) FileInfos {
+// fileBucketNames returns the bucket names of the file list of the generation
+func (s *Adapter) fileBucketNames(keyName []byte, generation uint64) [][]byte {
+	if generation == 0 {
+		return [][]byte{keyName}
+	}
+	return [][]byte{[]byte(fileBucket), keyName, itob(generation)}
+}
+
+// activeFileBucket returns the bucket of the active file list of the root path, nil if not exists.
+// It returns an error if the setting could not be read, the active file list is unknown then.
+func (s *Adapter) activeFileBucket(tx *bolt.Tx, keyName []byte) (*bolt.Bucket, error) {
+	setting, err := s.readSettingTx(tx, keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	var generation uint64
+	if setting != nil {
+		generation = setting.Generation
+	}
+	return s.nestedBucket(tx, s.fileBucketNames(keyName, generation)...), nil
+}
+
+// readFileInfos reads the file infos of the keys in the bucket,
+// the entries could not be decoded are skipped and returned as the error
+func (s *Adapter) readFileInfos(db *bolt.DB, bucketName []byte, keys []string) (FileInfos, error) {
 	var infos FileInfos = NewFileInfos()
 	if db == nil {
-		return infos
+		return infos, nil
 	}
+
+	var decodeErr error
 	// load file list
-	_ = db.View(func(tx *bolt.Tx) error {
+	err := db.View(func(tx *bolt.Tx) error {
 		bucket := tx.Bucket(bucketName)
 		if bucket == nil {
 			return nil
 		}
 
 		for _, key := range keys {
-			var info *FileInfo
-			_ = sonic.Unmarshal(bucket.Get(s.pathKey(key)), &info)
-			if info != nil {
+			v := bucket.Get(s.pathKey(key))
+			if v == nil {
+				continue
+			}
+			if info, err := decodeFileInfo(s.pathKey(key), v); err != nil {
+				decodeErr = multierr.Append(decodeErr, fmt.Errorf("decode \"%s\" error: %w", key, err))
+			} else {
 				infos.Put(key, info)
 			}
-
 		}
 		return nil
 	})
 
-	return infos
+	return infos, multierr.Append(err, decodeErr)
 }
 
-func (s *Adapter) readAllFileInfos(db *bolt.DB, bucketName []byte) FileInfos {
+// readAllFileInfos reads the active file list of the root path,
+// the entries could not be decoded are skipped and returned as the error
+func (s *Adapter) readAllFileInfos(db *bolt.DB, keyName []byte) (FileInfos, error) {
 	var infos FileInfos = NewFileInfos()
 	if db == nil {
-		return infos
+		return infos, nil
 	}
 
+	var decodeErr error
 	// load file list
-	_ = db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket(bucketName)
-		if bucket == nil {
-			return nil
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket, err := s.activeFileBucket(tx, keyName)
+		if err != nil || bucket == nil {
+			return err
 		}
 
-		_ = bucket.ForEach(func(k, v []byte) error {
-			var info *FileInfo
-			_ = sonic.Unmarshal(v, &info)
-			if info != nil {
+		return bucket.ForEach(func(k, v []byte) error {
+			info, err := decodeFileInfo(k, v)
+			if err != nil {
+				decodeErr = multierr.Append(decodeErr, fmt.Errorf("decode \"%s\" error: %w", k, err))
+			} else {
 				infos.Put(string(k), info)
 			}
 			return nil
 		})
-
-		return nil
 	})
 
-	return infos
+	return infos, multierr.Append(err, decodeErr)
 }
 
-func (s *Adapter) putFileInfos(db *bolt.DB, bucketName []byte, infos FileInfos, deleteHistory bool) error {
+// putFileInfos puts the file infos to the bucket of the names in chunks of 1000 entries,
+// each chunk is a transaction, so the bucket is incomplete if any chunk fails
+func (s *Adapter) putFileInfos(db *bolt.DB, infos FileInfos, bucketNames ...[]byte) error {
 	if db == nil {
 		return nil
 	}
 
-	if deleteHistory {
-		// get old keys
-		_ = db.Update(func(tx *bolt.Tx) error {
-			return tx.DeleteBucket(bucketName)
-		})
-	}
-
 	var n int64
 	// split infos into chunks
 	var chunks []FileInfos
@@ -107,7 +154,7 @@ func (s *Adapter) putFileInfos(db *bolt.DB, bucketName []byte, infos FileInfos,
 	for _, chunk := range chunks {
 		err = multierr.Append(err,
 			db.Batch(func(tx *bolt.Tx) error {
-				bucket, err := tx.CreateBucketIfNotExists(bucketName)
+				bucket, err := s.createNestedBucket(tx, bucketNames...)
 				if err != nil {
 					return err
 				}
@@ -118,12 +165,7 @@ func (s *Adapter) putFileInfos(db *bolt.DB, bucketName []byte, infos FileInfos,
 						continue
 					}
 
-					j, _ := sonic.Marshal(info)
-					if len(j) == 0 { // json is empty or error
-						continue
-					}
-
-					if err = bucket.Put(s.pathKey(path), j); err != nil {
+					if err = s.putFileInfo(bucket, path, info); err != nil {
 						return err
 					}
 					n++
@@ -131,45 +173,303 @@ func (s *Adapter) putFileInfos(db *bolt.DB, bucketName []byte, infos FileInfos,
 				return nil
 			}),
 		)
-		fmt.Printf("\rSaved %d/%d file informations to \"%s\"", n, len(infos), db.Path())
 	}
-	fmt.Println()
 	return err
 }
 
-func (s *Adapter) putSetting(db *bolt.DB, keyName []byte, setting *adapterSetting) error {
-	if db == nil {
-		return nil
+// applySnapshotEntries applies the changes of a snapshot to the file list in the bucket of the names
+func (s *Adapter) applySnapshotEntries(tx *bolt.Tx, bucketNames [][]byte, entries map[string]*snapshotEntry) error {
+	bucket, err := s.createNestedBucket(tx, bucketNames...)
+	if err != nil {
+		return err
 	}
-	return db.Update(func(tx *bolt.Tx) error {
-		bucket, err := tx.CreateBucketIfNotExists([]byte("setting"))
+
+	for path, entry := range entries {
+		if entry.Info == nil {
+			err = bucket.Delete(s.pathKey(path))
+		} else {
+			err = s.putFileInfo(bucket, path, entry.Info)
+		}
 		if err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		j, _ := sonic.Marshal(setting)
-		if len(j) == 0 { // json is empty or error
+// writeGeneration writes the file list of the generation "to" by merging the entries with the generation "from"
+// in the order of the keys, the not changed values are copied as they are.
+// It writes in chunks of 1000 entries, each chunk is a transaction, so the bucket is incomplete if any chunk fails.
+func (s *Adapter) writeGeneration(ctx context.Context, db *bolt.DB, rootPath string, from, to uint64, entries map[string]*snapshotEntry, total int64) error {
+	keyName := s.pathKey(rootPath)
+	progress := s.progressReporter()
+
+	// the keys of the entries are formatted paths
+	keys := make([]string, 0, len(entries))
+	for key := range entries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var chunkKeys, chunkValues [][]byte
+	var written int
+	var flush = func() error {
+		if len(chunkKeys) == 0 {
+			return nil
+		} else if err := ctx.Err(); err != nil {
+			return err
+		}
+		err := db.Update(func(tx *bolt.Tx) error {
+			bucket, err := s.createNestedBucket(tx, s.fileBucketNames(keyName, to)...)
+			if err != nil {
+				return err
+			}
+			for i := range chunkKeys {
+				if err = bucket.Put(chunkKeys[i], chunkValues[i]); err != nil {
+					return err
+				}
+			}
 			return nil
+		})
+		written += len(chunkKeys)
+		chunkKeys, chunkValues = chunkKeys[:0], chunkValues[:0]
+		progress.SaveProgress(rootPath, int64(written), total)
+		return err
+	}
+	var put = func(k, v []byte) error {
+		chunkKeys, chunkValues = append(chunkKeys, k), append(chunkValues, v)
+		if len(chunkKeys) >= 1000 {
+			return flush()
+		}
+		return nil
+	}
+
+	reader := s.newBucketReader(db, s.fileBucketNames(keyName, from)...)
+	var i int
+	for k, v := reader.peek(); k != nil || i < len(keys); k, v = reader.peek() {
+		var err error
+		if i < len(keys) && (k == nil || keys[i] <= string(k)) {
+			// the changed entry replaces the saved one, the deleted entry is not written
+			key := []byte(keys[i])
+			if info := entries[keys[i]].Info; info != nil {
+				err = put(key, encodeFileInfo(key, info))
+			}
+			if k != nil && keys[i] == string(k) {
+				reader.pop()
+			}
+			i++
+		} else {
+			err = put(k, v)
+			reader.pop()
 		}
+		if err != nil {
+			return err
+		}
+	}
+	if reader.err != nil {
+		return reader.err
+	}
+
+	return flush()
+}
+
+// putFileInfo puts the file info to the bucket in the binary encoding
+func (s *Adapter) putFileInfo(bucket *bolt.Bucket, path string, info *FileInfo) error {
+	key := s.pathKey(path)
+	return bucket.Put(key, encodeFileInfo(key, info))
+}
 
-		return bucket.Put(keyName, j)
+// nextGeneration returns a new generation of the file list of the root path, it's never used before
+func (s *Adapter) nextGeneration(db *bolt.DB, keyName []byte) (uint64, error) {
+	var generation uint64
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := s.createNestedBucket(tx, []byte(fileBucket), keyName)
+		if err != nil {
+			return err
+		}
+		generation, err = bucket.NextSequence()
+		return err
 	})
+	return generation, err
 }
 
-func (s *Adapter) readSetting(db *bolt.DB, keyName []byte) *adapterSetting {
-	if db == nil {
+// deleteFileBucket deletes the file list of the generation of the root path
+func (s *Adapter) deleteFileBucket(tx *bolt.Tx, keyName []byte, generation uint64) error {
+	names := s.fileBucketNames(keyName, generation)
+	var parent interface {
+		Bucket(name []byte) *bolt.Bucket
+		DeleteBucket(name []byte) error
+	} = tx
+	if len(names) > 1 {
+		if parent = s.nestedBucket(tx, names[:len(names)-1]...); parent == nil {
+			return nil
+		}
+	}
+
+	if parent.Bucket(names[len(names)-1]) == nil {
 		return nil
 	}
-	var setting *adapterSetting
-	_ = db.View(func(tx *bolt.Tx) error {
-		bucket := tx.Bucket([]byte("setting"))
-		if bucket == nil {
-			return nil
+	return parent.DeleteBucket(names[len(names)-1])
+}
+
+// recover discards the file lists and snapshots of the root path which are left by an interrupted Save
+func (s *Adapter) recover(db *bolt.DB, keyName []byte) error {
+	return db.Update(func(tx *bolt.Tx) error {
+		// nothing is discarded if the active file list is unknown
+		setting, err := s.readSettingTx(tx, keyName)
+		if err != nil {
+			return err
+		}
+
+		var generation uint64
+		if setting != nil {
+			generation = setting.Generation
+		}
+
+		// the file lists which are not active
+		if bucket := s.nestedBucket(tx, []byte(fileBucket), keyName); bucket != nil {
+			var incomplete [][]byte
+			_ = bucket.ForEach(func(k, v []byte) error {
+				if v == nil && btoi(k) != generation {
+					incomplete = append(incomplete, k)
+				}
+				return nil
+			})
+
+			for _, k := range incomplete {
+				log.Printf("[WARN] discard the incomplete file list #%d of \"%s\"", btoi(k), keyName)
+				if err := bucket.DeleteBucket(k); err != nil {
+					return err
+				}
+			}
+		}
+
+		// the snapshot entries without the snapshot
+		if bucket := s.nestedBucket(tx, []byte(snapshotDeltaBucket), keyName); bucket != nil {
+			metaBucket := s.nestedBucket(tx, []byte(snapshotBucket), keyName)
+
+			var incomplete [][]byte
+			_ = bucket.ForEach(func(k, v []byte) error {
+				if v == nil && (metaBucket == nil || metaBucket.Get(k) == nil) {
+					incomplete = append(incomplete, k)
+				}
+				return nil
+			})
+
+			for _, k := range incomplete {
+				log.Printf("[WARN] discard the incomplete snapshot #%d of \"%s\"", btoi(k), keyName)
+				if err := bucket.DeleteBucket(k); err != nil {
+					return err
+				}
+			}
 		}
 
-		_ = sonic.Unmarshal(bucket.Get(keyName), &setting)
 		return nil
 	})
+}
 
-	return setting
+// migrate rewrites the file list and the snapshot entries of the root path in the current schema,
+// the file list is written to a new generation and swapped as Save does
+func (s *Adapter) migrate(db *bolt.DB, keyName []byte, setting *adapterSetting) error {
+	infos, err := s.readAllFileInfos(db, keyName)
+	if err != nil {
+		return err
+	}
+	generation, err := s.nextGeneration(db, keyName)
+	if err != nil {
+		return err
+	}
+	if err = s.putFileInfos(db, infos, s.fileBucketNames(keyName, generation)...); err != nil {
+		return err
+	}
+
+	return db.Update(func(tx *bolt.Tx) error {
+		if deltaBucket := s.nestedBucket(tx, []byte(snapshotDeltaBucket), keyName); deltaBucket != nil {
+			var ids [][]byte
+			_ = deltaBucket.ForEach(func(k, v []byte) error {
+				if v == nil {
+					ids = append(ids, k)
+				}
+				return nil
+			})
+
+			for _, id := range ids {
+				bucket := deltaBucket.Bucket(id)
+				entries := make(map[string][]byte)
+				if err := bucket.ForEach(func(k, v []byte) error {
+					entry, err := decodeSnapshotEntry(k, v)
+					if err != nil {
+						return err
+					}
+					entries[string(k)] = encodeSnapshotEntry(k, entry)
+					return nil
+				}); err != nil {
+					return err
+				}
+
+				for k, v := range entries {
+					if err := bucket.Put([]byte(k), v); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		oldGeneration := setting.Generation
+		setting.Generation = generation
+		setting.Schema = schemaVersion
+		if err := s.putSettingTx(tx, keyName, setting); err != nil {
+			return err
+		}
+		return s.deleteFileBucket(tx, keyName, oldGeneration)
+	})
+}
+
+func (s *Adapter) putSettingTx(tx *bolt.Tx, keyName []byte, setting *adapterSetting) error {
+	bucket, err := tx.CreateBucketIfNotExists([]byte(settingBucket))
+	if err != nil {
+		return err
+	}
+
+	j, _ := sonic.Marshal(setting)
+	if len(j) == 0 { // json is empty or error
+		return nil
+	}
+
+	return bucket.Put(keyName, j)
+}
+
+// readSetting reads the setting of the root path, nil if it's never saved
+func (s *Adapter) readSetting(db *bolt.DB, keyName []byte) (*adapterSetting, error) {
+	if db == nil {
+		return nil, nil
+	}
+	var setting *adapterSetting
+	err := db.View(func(tx *bolt.Tx) error {
+		var err error
+		setting, err = s.readSettingTx(tx, keyName)
+		return err
+	})
+
+	return setting, err
+}
+
+// readSettingTx reads the setting of the root path, nil if it's never saved.
+// A setting could not be decoded is an error rather than a root path never saved, run the "repair" command to remove it.
+func (s *Adapter) readSettingTx(tx *bolt.Tx, keyName []byte) (*adapterSetting, error) {
+	bucket := tx.Bucket([]byte(settingBucket))
+	if bucket == nil {
+		return nil, nil
+	}
+
+	v := bucket.Get(keyName)
+	if v == nil {
+		return nil, nil
+	}
+
+	var setting *adapterSetting
+	if err := sonic.Unmarshal(v, &setting); err != nil {
+		return nil, fmt.Errorf("decode the setting of \"%s\" error: %w", keyName, err)
+	}
+	return setting, nil
 }
@@ -0,0 +1,274 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	bolt "go.etcd.io/bbolt"
+)
+
+func TestRecoverIncompleteSave(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "a")
+	adapter := NewAdapter("md5")
+	watchOnce(t, adapter, rootPath, testOption())
+	generation := adapter.setting(rootPath).Generation
+
+	// a file list and a snapshot left by a crashed Save
+	db, err := adapter.openDB(adapter.getDbPath(rootPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyName := adapter.pathKey(rootPath)
+	if err = adapter.putFileInfos(db, NewFileInfos(), adapter.fileBucketNames(keyName, generation+10)...); err != nil {
+		t.Fatal(err)
+	}
+	if err = db.Update(func(tx *bolt.Tx) error {
+		_, err := adapter.createNestedBucket(tx, []byte(snapshotDeltaBucket), keyName, itob(99))
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+	_ = db.Close()
+
+	adapter = NewAdapter("md5")
+	if err = adapter.LoadAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	if adapter.setting(rootPath).Generation != generation || savedFiles(t, adapter, rootPath).Len() != 1 {
+		t.Fatal("the active file list is changed by the recovery")
+	}
+
+	db, err = adapter.openDB(adapter.getDbPath(rootPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_ = db.View(func(tx *bolt.Tx) error {
+		if adapter.nestedBucket(tx, adapter.fileBucketNames(keyName, generation+10)...) != nil {
+			t.Error("the incomplete file list is not discarded")
+		}
+		if adapter.nestedBucket(tx, []byte(snapshotDeltaBucket), keyName, itob(99)) != nil {
+			t.Error("the incomplete snapshot is not discarded")
+		}
+		return nil
+	})
+}
+
+func TestSaveInterrupted(t *testing.T) {
+	rootPath := t.TempDir()
+	for i := 0; i < 10; i++ {
+		writeFile(t, filepath.Join(rootPath, fmt.Sprint(i)), "x")
+	}
+	adapter := NewAdapter("md5")
+	watchOnce(t, adapter, rootPath, testOption())
+	generation := adapter.setting(rootPath).Generation
+
+	// more changes than the half of the files are written to a new generation
+	infos := walkOnce(t, rootPath, testOption())
+	for i := 0; i < 15; i++ {
+		path := filepath.Join(rootPath, fmt.Sprint("n", i))
+		infos.Put(path, &FileInfo{FileName: fmt.Sprint("n", i), FilePath: path, FileSize: 1, FileMode: 0644})
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := adapter.SaveContext(ctx, rootPath, infos); err != context.Canceled {
+		t.Fatalf("the canceled save returns %v", err)
+	}
+	if adapter.setting(rootPath).Generation != generation || savedFiles(t, adapter, rootPath).Len() != 10 {
+		t.Fatal("the file list is changed by the canceled save")
+	}
+
+	if err := adapter.Save(rootPath, infos); err != nil {
+		t.Fatal(err)
+	}
+	if adapter.setting(rootPath).Generation == generation || savedFiles(t, adapter, rootPath).Len() != 25 {
+		t.Fatal("the file list is not swapped by the save")
+	}
+
+	// the old generation is deleted with the swap
+	db, err := adapter.openDB(adapter.getDbPath(rootPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	_ = db.View(func(tx *bolt.Tx) error {
+		var generations int
+		_ = adapter.nestedBucket(tx, []byte(fileBucket), adapter.pathKey(rootPath)).ForEach(func(k, v []byte) error {
+			generations++
+			return nil
+		})
+		if generations != 1 {
+			t.Errorf("%d generations are kept", generations)
+		}
+		return nil
+	})
+}
+
+// baselineFileInfo is the FileInfo written by the releases before the schema versions
+type baselineFileInfo struct {
+	FileName    string    `json:"name"`
+	FilePath    string    `json:"path"`
+	FileSize    int64     `json:"size"`
+	FileMode    uint32    `json:"mode"`
+	FileHashSum []byte    `json:"hash_sum"`
+	FileMtime   time.Time `json:"mtime"`
+}
+
+// putBaselineDB writes the file list as the json values in the bucket of the root path,
+// and the json setting without the schema and the generation, as the releases before the schema versions did
+func putBaselineDB(tb testing.TB, db *bolt.DB, rootPath string, infos FileInfos) {
+	tb.Helper()
+	keyName := []byte(formatPath(rootPath))
+	if err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(keyName)
+		if err != nil {
+			return err
+		}
+		for path, info := range infos {
+			j, err := sonic.Marshal(&baselineFileInfo{FileName: info.FileName, FilePath: info.FilePath, FileSize: info.FileSize,
+				FileMode: info.FileMode, FileHashSum: info.FileHashSum, FileMtime: info.FileMtime})
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put([]byte(formatPath(path)), j); err != nil {
+				return err
+			}
+		}
+
+		settings, err := tx.CreateBucketIfNotExists([]byte("setting"))
+		if err != nil {
+			return err
+		}
+		j, err := sonic.Marshal(map[string]any{"root_path": rootPath, "at": time.Now(), "hash_algorithm": "md5"})
+		if err != nil {
+			return err
+		}
+		return settings.Put(keyName, j)
+	}); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func TestMigrateBaselineDB(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "a")
+	writeFile(t, filepath.Join(rootPath, "dir", "b.txt"), "b")
+	writeFile(t, filepath.Join(rootPath, "dir", "sub", "c.txt"), "c")
+	adapter := NewAdapter("md5")
+	watchOnce(t, adapter, rootPath, testOption())
+	want := savedFiles(t, adapter, rootPath)
+
+	// replace the db with the one written by a baseline release
+	if err := os.Remove(adapter.getDbPath(rootPath)); err != nil {
+		t.Fatal(err)
+	}
+	db, err := adapter.openDB(adapter.getDbPath(rootPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	putBaselineDB(t, db, rootPath, want)
+	_ = db.Close()
+
+	adapter = NewAdapter("md5")
+	if err = adapter.LoadAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	setting := adapter.setting(rootPath)
+	if setting == nil || setting.Schema != schemaVersion || setting.Generation == 0 {
+		t.Fatalf("the setting is not migrated: %+v", setting)
+	}
+
+	got := savedFiles(t, adapter, rootPath)
+	if fmt.Sprint(relNames(rootPath, got)) != fmt.Sprint(relNames(rootPath, want)) {
+		t.Fatalf("migrated %v, want %v", relNames(rootPath, got), relNames(rootPath, want))
+	}
+	for path, info := range want {
+		if migrated, _ := got.Get(path); !bytes.Equal(migrated.FileHashSum, info.FileHashSum) || !migrated.FileMtime.Equal(info.FileMtime) {
+			t.Errorf("\"%s\" is migrated as %+v, want %+v", path, migrated, info)
+		}
+	}
+
+	// the json file list is deleted with the swap
+	db, err = adapter.openDB(adapter.getDbPath(rootPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(adapter.pathKey(rootPath)) != nil {
+			t.Error("the baseline file list is kept")
+		}
+		return nil
+	})
+	_ = db.Close()
+
+	if events := watchOnce(t, adapter, rootPath, testOption()); len(events) != 0 {
+		t.Fatalf("the scan after the migration emits %v", events)
+	}
+}
+
+// BenchmarkLoadFileList compares the db size and the load time of a populated file list
+// written by a baseline release (json) and by the current schema (binary)
+func BenchmarkLoadFileList(b *testing.B) {
+	const count = 20000
+	rootPath := "/data/projects/some-project"
+	_, sample := testFileInfo()
+	infos := NewFileInfos()
+	for i := 0; i < count; i++ {
+		path := filepath.Join(rootPath, "src", fmt.Sprint("module", i%50), fmt.Sprint("file", i, ".go"))
+		info := *sample
+		info.FileName = filepath.Base(path)
+		info.FilePath = path
+		infos.Put(path, &info)
+	}
+
+	for _, bb := range []struct {
+		name string
+		put  func(b *testing.B, adapter *Adapter, db *bolt.DB)
+	}{
+		{"json", func(b *testing.B, adapter *Adapter, db *bolt.DB) {
+			putBaselineDB(b, db, rootPath, infos)
+		}},
+		{"binary", func(b *testing.B, adapter *Adapter, db *bolt.DB) {
+			keyName := adapter.pathKey(rootPath)
+			if err := adapter.putFileInfos(db, infos, adapter.fileBucketNames(keyName, 1)...); err != nil {
+				b.Fatal(err)
+			}
+			if err := db.Update(func(tx *bolt.Tx) error {
+				return adapter.putSettingTx(tx, keyName, &adapterSetting{RootPath: rootPath, Generation: 1, Schema: schemaVersion})
+			}); err != nil {
+				b.Fatal(err)
+			}
+		}},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			adapter := NewAdapter("md5")
+			path := filepath.Join(b.TempDir(), DBFile)
+			db, err := adapter.openDB(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer db.Close()
+			bb.put(b, adapter, db)
+			stat, err := os.Stat(path)
+			if err != nil {
+				b.Fatal(err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				loaded, err := adapter.readAllFileInfos(db, adapter.pathKey(rootPath))
+				if err != nil || loaded.Len() != count {
+					b.Fatalf("%d files are loaded: %v", loaded.Len(), err)
+				}
+			}
+			b.ReportMetric(float64(stat.Size()), "db-bytes")
+		})
+	}
+}
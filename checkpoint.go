@@ -0,0 +1,106 @@
+package watcher
+
+import (
+	"github.com/bytedance/sonic"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"time"
+)
+
+// checkpointBucket is the bucket of the progress of the unfinished scans, a scan of a root path is stored
+// in the nested bucket "checkpoint" -> root path, with the meta in the key "meta" and the walked files in the bucket "files".
+// The checkpoint is removed when the file list is saved, so its existence means the last scan is incomplete.
+const checkpointBucket = "checkpoint"
+const checkpointFilesBucket = "files"
+const checkpointMetaKey = "meta"
+
+// checkpointInterval is the interval of saving the progress of walking and hashing
+const checkpointInterval = 10 * time.Second
+
+type checkpointMeta struct {
+	StartedAt time.Time `yaml:"started_at" json:"started_at"`
+	UpdatedAt time.Time `yaml:"updated_at" json:"updated_at"`
+	Dirs      int       `yaml:"dirs" json:"dirs"` // the count of the walked directories
+}
+
+// ScanIncomplete returns true if the last scan of the root path was interrupted before its file list was saved,
+// the next scan resumes from its checkpoint
+func (s *Adapter) ScanIncomplete(rootPath string) bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.incomplete[formatPath(rootPath)]
+}
+
+// putCheckpoint adds the walked directories and their files to the checkpoint of the root path
+func (s *Adapter) putCheckpoint(db *bolt.DB, keyName []byte, dirs int, infos FileInfos) error {
+	err := db.Update(func(tx *bolt.Tx) error {
+		bucket, err := s.createNestedBucket(tx, []byte(checkpointBucket), keyName)
+		if err != nil {
+			return err
+		}
+
+		meta := &checkpointMeta{StartedAt: time.Now()}
+		if v := bucket.Get([]byte(checkpointMetaKey)); v != nil {
+			// the meta is only the progress, a bad one is replaced
+			if err = sonic.Unmarshal(v, &meta); err != nil {
+				log.Printf("[WARN] the checkpoint of \"%s\" could not be read, it's replaced: %s", keyName, err)
+				meta = &checkpointMeta{StartedAt: time.Now()}
+			}
+		}
+		meta.UpdatedAt = time.Now()
+		meta.Dirs += dirs
+
+		j, err := sonic.Marshal(meta)
+		if err != nil {
+			return err
+		}
+		if err = bucket.Put([]byte(checkpointMetaKey), j); err != nil {
+			return err
+		}
+
+		filesBucket, err := bucket.CreateBucketIfNotExists([]byte(checkpointFilesBucket))
+		if err != nil {
+			return err
+		}
+		for path, info := range infos {
+			if err = s.putFileInfo(filesBucket, path, info); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err == nil {
+		// the key name is the formatted root path
+		s.mu.Lock()
+		s.incomplete[string(keyName)] = true
+		s.mu.Unlock()
+	}
+	return err
+}
+
+// readCheckpointMeta reads the meta of the unfinished scan of the root path, nil if there is no checkpoint
+func (s *Adapter) readCheckpointMeta(db *bolt.DB, keyName []byte) (*checkpointMeta, error) {
+	var meta *checkpointMeta
+	err := db.View(func(tx *bolt.Tx) error {
+		bucket := s.nestedBucket(tx, []byte(checkpointBucket), keyName)
+		if bucket == nil {
+			return nil
+		}
+
+		meta = &checkpointMeta{}
+		if v := bucket.Get([]byte(checkpointMetaKey)); v != nil {
+			return sonic.Unmarshal(v, &meta)
+		}
+		return nil
+	})
+	return meta, err
+}
+
+// deleteCheckpoint removes the checkpoint of the root path if exists
+func (s *Adapter) deleteCheckpoint(tx *bolt.Tx, keyName []byte) error {
+	bucket := tx.Bucket([]byte(checkpointBucket))
+	if bucket == nil || bucket.Bucket(keyName) == nil {
+		return nil
+	}
+	return bucket.DeleteBucket(keyName)
+}
@@ -0,0 +1,91 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// cancelRecorder cancels the scan when the first FilesWalked is reported
+type cancelRecorder struct {
+	NopProgressReporter
+	cancel context.CancelFunc
+}
+
+func (r *cancelRecorder) FilesWalked(string, int64) {
+	r.cancel()
+}
+
+func TestResumeCheckpoint(t *testing.T) {
+	rootPath := t.TempDir()
+	for i := 0; i < 2*walkedReportInterval; i++ {
+		writeFile(t, filepath.Join(rootPath, fmt.Sprintf("d%d", i%7), fmt.Sprint(i)), "x")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	adapter := NewAdapter("md5")
+	adapter.SetProgressReporter(&cancelRecorder{cancel: cancel})
+	option := testOption()
+	option.Parallelism = 1
+	w := NewWatcher(adapter)
+	if err := w.Add(rootPath, option); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.WatchContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("the scan is not canceled: %v", err)
+	}
+
+	// the walked directories are checkpointed when the walk fails
+	loaded := NewAdapter("md5")
+	if err := loaded.LoadAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	if !loaded.ScanIncomplete(rootPath) {
+		t.Fatal("the canceled scan is not incomplete")
+	}
+	db, err := loaded.openDB(loaded.getDbPath(rootPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	meta, err := loaded.readCheckpointMeta(db, loaded.pathKey(rootPath))
+	db.Close()
+	if err != nil || meta == nil || meta.Dirs == 0 {
+		t.Fatalf("unexpected checkpoint: %+v, %v", meta, err)
+	}
+
+	// the directories changed after the checkpoint are listed again
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 7; i++ {
+		writeFile(t, filepath.Join(rootPath, fmt.Sprintf("d%d", i), "new"), "x")
+	}
+	events := watchOnce(t, loaded, rootPath, testOption())
+	want := walkOnce(t, rootPath, testOption())
+	if len(events) != want.Len() {
+		t.Errorf("%d events of %d files", len(events), want.Len())
+	}
+	for _, event := range events {
+		if event.Op != Create || !want.Has(event.Path) {
+			t.Errorf("unexpected event: %s", event)
+		}
+	}
+
+	// the checkpoint is removed with the saved file list
+	reloaded := NewAdapter("md5")
+	if err = reloaded.LoadAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	if loaded.ScanIncomplete(rootPath) || reloaded.ScanIncomplete(rootPath) {
+		t.Error("the resumed scan is still incomplete")
+	}
+	if !reflect.DeepEqual(relNames(rootPath, savedFiles(t, loaded, rootPath)), relNames(rootPath, want)) {
+		t.Error("the saved file list differs from a full walk")
+	}
+	if report, err := loaded.CheckDB(loaded.getDbPath(rootPath)); err != nil || !report.OK() {
+		t.Errorf("unexpected report: %+v, %v", report, err)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-mixed/watcher"
+	"github.com/go-mixed/watcher/cmd/internal/conf"
+	"path/filepath"
+)
+
+// confirm confirms the deletion of a suspect watched path, its changes are saved by the next watch.
+// The path could be a missing or unmounted mount point in a watched path, its saved files are reported deleted then.
+//
+//	confirm <path>
+func confirm(config *conf.Conf, adapter *watcher.Adapter, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: confirm <path>")
+	}
+
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+
+	rootPath := findRootPath(config.Paths(), path)
+	if rootPath == "" {
+		return fmt.Errorf("path is not in a watched path: %s", path)
+	} else if rootPath != path {
+		return adapter.ConfirmMountPointDeletion(rootPath, path)
+	}
+	return adapter.ConfirmDeletion(path)
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/go-mixed/watcher"
+	"github.com/go-mixed/watcher/cmd/internal/conf"
+	"go.uber.org/multierr"
+	"os"
+)
+
+// dbFiles returns the db files in the args, or all db files of the config if no args
+func dbFiles(config *conf.Conf, adapter *watcher.Adapter, args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+
+	var files []string
+	for _, file := range adapter.DBFiles(config.Paths()...) {
+		// skip the db files which have not been created yet
+		if _, err := os.Stat(file); err == nil {
+			files = append(files, file)
+		}
+	}
+	return files
+}
+
+// check verifies the integrity of the db files
+//
+//	check [db-file...]
+func check(config *conf.Conf, adapter *watcher.Adapter, args []string) error {
+	var err error
+	var failed int
+	for _, file := range dbFiles(config, adapter, args) {
+		report, e := adapter.CheckDB(file)
+		if e != nil {
+			err = multierr.Append(err, fmt.Errorf("checking \"%s\" error: %w", file, e))
+			continue
+		}
+
+		printReport(report)
+		if !report.OK() {
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		err = multierr.Append(err, fmt.Errorf("%d db files are not healthy, run the \"repair\" command", failed))
+	}
+	return err
+}
+
+// repair removes or quarantines the undecodable entries of the db files
+//
+//	repair [--quarantine] [db-file...]
+func repair(config *conf.Conf, adapter *watcher.Adapter, args []string) error {
+	var quarantine bool
+
+	flags := flag.NewFlagSet("repair", flag.ContinueOnError)
+	flags.BoolVar(&quarantine, "quarantine", false, "move the undecodable entries to the \"quarantine\" bucket rather than dropping them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	var err error
+	for _, file := range dbFiles(config, adapter, flags.Args()) {
+		report, e := adapter.RepairDB(file, quarantine)
+		if e != nil {
+			err = multierr.Append(err, fmt.Errorf("repairing \"%s\" error: %w", file, e))
+			continue
+		}
+
+		action := "dropped"
+		if quarantine {
+			action = "quarantined"
+		}
+		fmt.Printf("%s: %d entries %s\n", file, len(report.BadEntries), action)
+		for _, entry := range report.BadEntries {
+			fmt.Printf("  [%s] %q: %s\n", entry.Bucket, entry.Key, entry.Error)
+		}
+	}
+	return err
+}
+
+// compact compacts the db files
+//
+//	compact [db-file...]
+func compact(config *conf.Conf, adapter *watcher.Adapter, args []string) error {
+	var err error
+	for _, file := range dbFiles(config, adapter, args) {
+		before, after, e := adapter.CompactDB(file)
+		if e != nil {
+			err = multierr.Append(err, fmt.Errorf("compacting \"%s\" error: %w", file, e))
+			continue
+		}
+		fmt.Printf("%s: %s -> %s\n", file, watcher.ByteCountIEC(before), watcher.ByteCountIEC(after))
+	}
+	return err
+}
+
+// stats prints the sizes and entry counts of the buckets of the db files
+//
+//	stats [db-file...]
+func stats(config *conf.Conf, adapter *watcher.Adapter, args []string) error {
+	var err error
+	for _, file := range dbFiles(config, adapter, args) {
+		dbStats, e := adapter.StatsDB(file)
+		if e != nil {
+			err = multierr.Append(err, fmt.Errorf("reading \"%s\" error: %w", file, e))
+			continue
+		}
+
+		fmt.Printf("%s: %s\n", dbStats.Path, watcher.ByteCountIEC(dbStats.Size))
+		for _, bucket := range dbStats.Buckets {
+			fmt.Printf("  [%s] entries: %d, size: %s\n", bucket.Bucket, bucket.Entries, watcher.ByteCountIEC(bucket.Bytes))
+		}
+	}
+	return err
+}
+
+func printReport(report *watcher.DBReport) {
+	if report.OK() {
+		fmt.Printf("%s: ok\n", report.Path)
+		return
+	}
+
+	fmt.Printf("%s: %d corruptions, %d undecodable entries\n", report.Path, len(report.Corruptions), len(report.BadEntries))
+	for _, corruption := range report.Corruptions {
+		fmt.Printf("  %s\n", corruption)
+	}
+	for _, entry := range report.BadEntries {
+		fmt.Printf("  [%s] %q: %s\n", entry.Bucket, entry.Key, entry.Error)
+	}
+}
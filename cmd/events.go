@@ -0,0 +1,85 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/bytedance/sonic"
+	"github.com/go-mixed/watcher"
+	"strconv"
+)
+
+// events prints the journal entries after the cursor as json lines
+//
+//	events [--since <cursor>] [--limit <n>] [--consumer <name>]
+//
+// with --consumer, the entries are read from the last acknowledged cursor of the consumer
+// unless --since is set, and the consumer is registered if not exists.
+func events(adapter *watcher.Adapter, args []string) error {
+	var since uint64
+	var limit int
+	var consumer string
+
+	flags := flag.NewFlagSet("events", flag.ContinueOnError)
+	flags.Uint64Var(&since, "since", 0, "read the events after the cursor")
+	flags.IntVar(&limit, "limit", 0, "read at most n events, 0 for no limit")
+	flags.StringVar(&consumer, "consumer", "", "read the events from the last acknowledged cursor of the consumer")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if consumer != "" {
+		if err := adapter.RegisterConsumer(consumer); err != nil {
+			return err
+		}
+
+		sinceSet := false
+		flags.Visit(func(f *flag.Flag) {
+			sinceSet = sinceSet || f.Name == "since"
+		})
+		if !sinceSet {
+			cursor, err := adapter.ConsumerCursor(consumer)
+			if err != nil {
+				return err
+			}
+			since = cursor
+		}
+	}
+
+	entries, err := adapter.ReadEvents(since, limit)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		j, err := sonic.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(j))
+	}
+	return nil
+}
+
+// ack acknowledges the journal entries until the cursor for the consumer
+//
+//	ack --consumer <name> <cursor>
+func ack(adapter *watcher.Adapter, args []string) error {
+	var consumer string
+
+	flags := flag.NewFlagSet("ack", flag.ContinueOnError)
+	flags.StringVar(&consumer, "consumer", "", "the name of the consumer")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if consumer == "" || flags.NArg() != 1 {
+		return errors.New("usage: ack --consumer <name> <cursor>")
+	}
+
+	cursor, err := strconv.ParseUint(flags.Arg(0), 10, 64)
+	if err != nil {
+		return err
+	}
+	return adapter.Ack(consumer, cursor)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"github.com/go-mixed/watcher"
+	"github.com/go-mixed/watcher/cmd/internal/conf"
+	"path/filepath"
+	"strings"
+)
+
+// history prints the revisions of the path from the newest to the oldest
+//
+//	history <path>
+func history(config *conf.Conf, adapter *watcher.Adapter, args []string) error {
+	if len(args) != 1 {
+		return errors.New("usage: history <path>")
+	}
+
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+
+	rootPath := findRootPath(config.Paths(), path)
+	if rootPath == "" {
+		return fmt.Errorf("path is not in any watched path: %s", path)
+	}
+
+	revisions, err := adapter.FileHistory(rootPath, path)
+	if err != nil {
+		return err
+	}
+
+	for _, revision := range revisions {
+		line := fmt.Sprintf("#%d %s %s %q", revision.SnapshotID, revision.At.Format("2006-01-02 15:04:05"), revision.Op, revision.Path)
+		if revision.OldPath != "" {
+			line += fmt.Sprintf(" from %q", revision.OldPath)
+		}
+		if info := revision.Info; info != nil {
+			line += fmt.Sprintf(" size: %s mode: %s mtime: %s hash: %x",
+				watcher.ByteCountIEC(info.Size()),
+				info.Mode(),
+				info.ModTime().Format("2006-01-02 15:04:05"),
+				info.HashSum())
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+// findRootPath returns the deepest root path which contains the path
+func findRootPath(rootPaths []string, path string) string {
+	var found string
+	for _, rootPath := range rootPaths {
+		rootPath, err := filepath.Abs(rootPath)
+		if err != nil {
+			continue
+		}
+		if rel, err := filepath.Rel(rootPath, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			continue
+		}
+		if len(rootPath) > len(found) {
+			found = rootPath
+		}
+	}
+	return found
+}
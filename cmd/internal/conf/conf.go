@@ -2,30 +2,77 @@ package conf
 
 import (
 	"github.com/go-mixed/watcher"
+	"github.com/samber/lo"
 	"gopkg.in/yaml.v3"
 	"os"
 	"strings"
+	"time"
 )
 
 type Conf struct {
-	HashAlgorithm string      `yaml:"hash_algorithm"`
-	Watch         []WatchConf `yaml:"watch"`
+	HashAlgorithm     string                    `yaml:"hash_algorithm"`
+	SnapshotRetention watcher.SnapshotRetention `yaml:"snapshot_retention"`
+	JournalRetention  watcher.JournalRetention  `yaml:"journal_retention"`
+	ErrorPolicy       map[string]string         `yaml:"error_policy"`
+	Interval          time.Duration             `yaml:"interval"`
+	Watch             []WatchConf               `yaml:"watch"`
 }
 
+// ErrorPolicies returns the policies of the error classes, "abort" or "continue"
+func (c *Conf) ErrorPolicies() map[watcher.ErrorClass]watcher.ErrorPolicy {
+	policies := make(map[watcher.ErrorClass]watcher.ErrorPolicy)
+	for name, policy := range c.ErrorPolicy {
+		for class, className := range watcher.ErrorClasses {
+			if strings.EqualFold(className, name) {
+				if strings.EqualFold(policy, "abort") {
+					policies[class] = watcher.Abort
+				} else {
+					policies[class] = watcher.Continue
+				}
+			}
+		}
+	}
+	return policies
+}
+
+// Paths returns the root directories of the watched paths, the root directory of a file or a glob is the directory
+// its file list is saved in
 func (c *Conf) Paths() []string {
 	var paths []string
 	for _, watch := range c.Watch {
-		paths = append(paths, watch.Paths...)
+		for _, path := range watch.Paths {
+			if rootPath, err := watcher.RootPath(path); err == nil {
+				path = rootPath
+			}
+			if !lo.Contains(paths, path) {
+				paths = append(paths, path)
+			}
+		}
 	}
 	return paths
 }
 
 type WatchConf struct {
-	Paths        []string `yaml:"paths"`
-	Recursive    bool     `yaml:"recursive"`
-	IgnoreHidden bool     `yaml:"ignoreHidden"`
-	Ignore       []string `yaml:"ignore"`
-	Actions      []string `yaml:"actions"`
+	Paths             []string      `yaml:"paths"`
+	Recursive         bool          `yaml:"recursive"`
+	IgnoreHidden      bool          `yaml:"ignoreHidden"`
+	IgnoreHiddenFiles bool          `yaml:"ignoreHiddenFiles"`
+	IgnoreHiddenDirs  bool          `yaml:"ignoreHiddenDirs"`
+	Ignore            []string      `yaml:"ignore"`
+	Include           []string      `yaml:"include"`
+	Filter            FilterConf    `yaml:"filter"`
+	Symlinks          string        `yaml:"symlinks"`
+	OneFileSystem     bool          `yaml:"oneFileSystem"`
+	MaxDeleteCount    int           `yaml:"maxDeleteCount"`
+	MaxDeletePercent  int           `yaml:"maxDeletePercent"`
+	AllowMissing      bool          `yaml:"allowMissing"`
+	IgnoreFiles       bool          `yaml:"ignoreFiles"`
+	ExcludesFile      string        `yaml:"excludesFile"`
+	Actions           []string      `yaml:"actions"`
+	Parallelism       int           `yaml:"parallelism"`
+	FastScan          bool          `yaml:"fastScan"`
+	FastScanStatFiles bool          `yaml:"fastScanStatFiles"`
+	FullScanInterval  time.Duration `yaml:"fullScanInterval"`
 }
 
 func (w *WatchConf) Op() watcher.Op {
@@ -45,6 +92,56 @@ func (w *WatchConf) GitIgnore() *watcher.GitIgnore {
 	return watcher.CompileIgnoreLines(append([]string{watcher.DBFile}, w.Ignore...)...)
 }
 
+// SymlinkPolicy returns the policy of the symbolic links, default is keep
+func (w *WatchConf) SymlinkPolicy() watcher.SymlinkPolicy {
+	for policy, name := range watcher.SymlinkPolicies {
+		if strings.EqualFold(name, w.Symlinks) {
+			return policy
+		}
+	}
+	return watcher.SymlinkKeep
+}
+
+// FilterConf is the metadata filters of a watch group
+type FilterConf struct {
+	MinSize        int64     `yaml:"minSize"`
+	MaxSize        int64     `yaml:"maxSize"`
+	ModifiedAfter  time.Time `yaml:"modifiedAfter"`
+	ModifiedBefore time.Time `yaml:"modifiedBefore"`
+	MaxDepth       int       `yaml:"maxDepth"`
+	Types          []string  `yaml:"types"`
+	ExcludeEmpty   bool      `yaml:"excludeEmpty"`
+}
+
+func (f *FilterConf) FileFilter() watcher.FileFilter {
+	var types watcher.FileType
+	for _, name := range f.Types {
+		for typeV, typeStr := range watcher.FileTypes {
+			if strings.EqualFold(typeStr, name) {
+				types |= typeV
+			}
+		}
+	}
+
+	return watcher.FileFilter{
+		MinSize:        f.MinSize,
+		MaxSize:        f.MaxSize,
+		ModifiedAfter:  f.ModifiedAfter,
+		ModifiedBefore: f.ModifiedBefore,
+		MaxDepth:       f.MaxDepth,
+		Types:          types,
+		ExcludeEmpty:   f.ExcludeEmpty,
+	}
+}
+
+// IncludeGlobs returns the include patterns, nil for including all files
+func (w *WatchConf) IncludeGlobs() *watcher.GitIgnore {
+	if len(w.Include) == 0 {
+		return nil
+	}
+	return watcher.CompileIgnoreLines(w.Include...)
+}
+
 func LoadConf(paths ...string) *Conf {
 	var conf *Conf = &Conf{}
 
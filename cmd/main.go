@@ -1,10 +1,17 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"github.com/go-mixed/watcher"
 	"github.com/go-mixed/watcher/cmd/internal/conf"
+	"io"
+	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"syscall"
 )
 
 func main() {
@@ -13,27 +20,106 @@ func main() {
 	config := conf.LoadConf(currentDir + "/conf.yaml")
 
 	adapter := watcher.NewAdapter(config.HashAlgorithm)
+	adapter.SetSnapshotRetention(config.SnapshotRetention)
+	adapter.SetJournalRetention(config.JournalRetention)
+	progress := newProgressReporter()
+	adapter.SetProgressReporter(progress)
+	if writer, ok := progress.(io.Writer); ok {
+		log.SetOutput(writer)
+	}
+	for class, policy := range config.ErrorPolicies() {
+		adapter.SetErrorPolicy(class, policy)
+	}
+
+	command, args := "watch", os.Args[1:]
+	if len(args) > 0 {
+		command, args = args[0], args[1:]
+	}
+
+	var err error
+	switch command {
+	case "watch":
+		err = watch(config, adapter)
+	case "events":
+		err = events(adapter, args)
+	case "ack":
+		err = ack(adapter, args)
+	case "confirm":
+		err = confirm(config, adapter, args)
+	case "history":
+		err = history(config, adapter, args)
+	case "check":
+		err = check(config, adapter, args)
+	case "repair":
+		err = repair(config, adapter, args)
+	case "compact":
+		err = compact(config, adapter, args)
+	case "stats":
+		err = stats(config, adapter, args)
+	default:
+		err = fmt.Errorf("unknown command: %s", command)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func watch(config *conf.Conf, adapter *watcher.Adapter) error {
 	if err := adapter.LoadAll(config.Paths()...); err != nil {
-		panic(err)
+		return err
 	}
 
-	watch := watcher.NewWatcher(adapter)
+	w := watcher.NewWatcher(adapter)
+	w.OnEvent(func(event watcher.Event) {
+		log.Println(event)
+	})
 
-	for _, w := range config.Watch {
+	for _, wc := range config.Watch {
 		options := watcher.WatchOption{
-			Recursive:    w.Recursive,
-			IgnoreHidden: w.IgnoreHidden,
-			Ignore:       w.GitIgnore(),
-			Op:           w.Op(),
+			Recursive:         wc.Recursive,
+			IgnoreHidden:      wc.IgnoreHidden,
+			IgnoreHiddenFiles: wc.IgnoreHiddenFiles,
+			IgnoreHiddenDirs:  wc.IgnoreHiddenDirs,
+			Ignore:            wc.GitIgnore(),
+			Include:           wc.IncludeGlobs(),
+			Filter:            wc.Filter.FileFilter(),
+			Symlinks:          wc.SymlinkPolicy(),
+			OneFileSystem:     wc.OneFileSystem,
+			MaxDeleteCount:    wc.MaxDeleteCount,
+			MaxDeletePercent:  wc.MaxDeletePercent,
+			AllowMissing:      wc.AllowMissing,
+			IgnoreFiles:       wc.IgnoreFiles,
+			ExcludesFile:      wc.ExcludesFile,
+			Op:                wc.Op(),
+			Parallelism:       wc.Parallelism,
+			FastScan:          wc.FastScan,
+			FastScanStatFiles: wc.FastScanStatFiles,
+			FullScanInterval:  wc.FullScanInterval,
 		}
 
-		for _, path := range w.Paths {
-			if err := watch.Add(path, options); err != nil {
-				panic(err)
+		for _, path := range wc.Paths {
+			if err := w.Add(path, options); err != nil {
+				return err
 			}
 		}
 	}
 
-	watch.Watch()
+	// an interrupted scan is resumed by the next watch
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
+	var err error
+	if config.Interval <= 0 {
+		err = w.WatchContext(ctx)
+	} else {
+		// poll until interrupted
+		err = w.StartContext(ctx, config.Interval)
+	}
+	if errors.Is(err, context.Canceled) {
+		log.Println("interrupted")
+		return nil
+	}
+	return err
 }
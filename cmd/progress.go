@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-mixed/watcher"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// progressBarWidth is the count of the cells of the hashing bar
+const progressBarWidth = 30
+
+// progressRefresh is the min interval between two redraws of the line
+const progressRefresh = 100 * time.Millisecond
+
+// terminalProgress draws the progress of the scans on one line of a terminal, it's redrawn at most every progressRefresh
+type terminalProgress struct {
+	mu        sync.Mutex
+	out       io.Writer
+	startedAt map[string]time.Time
+	drawnAt   time.Time
+	width     int // the width of the drawn line
+}
+
+var _ watcher.ProgressReporter = (*terminalProgress)(nil)
+
+// newProgressReporter returns the terminal progress if stderr is a terminal, otherwise nothing is reported
+func newProgressReporter() watcher.ProgressReporter {
+	if info, err := os.Stderr.Stat(); err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return watcher.NopProgressReporter{}
+	}
+	return &terminalProgress{out: os.Stderr, startedAt: make(map[string]time.Time)}
+}
+
+func (p *terminalProgress) ScanStarted(rootPath string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.startedAt[rootPath] = time.Now()
+}
+
+func (p *terminalProgress) FilesWalked(rootPath string, files int64) {
+	p.draw(false, "walking \"%s\": %d files", rootPath, files)
+}
+
+func (p *terminalProgress) BytesHashed(rootPath string, hashed int64, total int64) {
+	var ratio float64 = 1
+	if total > 0 {
+		ratio = float64(hashed) / float64(total)
+	}
+	cells := int(ratio * progressBarWidth)
+	bar := strings.Repeat("#", cells) + strings.Repeat(".", progressBarWidth-cells)
+	p.draw(hashed == total, "hashing \"%s\": [%s] %0.2f%% %s/%s",
+		rootPath, bar, ratio*100, watcher.ByteCountIEC(hashed), watcher.ByteCountIEC(total))
+}
+
+func (p *terminalProgress) SaveProgress(rootPath string, saved int64, total int64) {
+	if total > 0 {
+		p.draw(saved >= total, "saving \"%s\": %d/%d files", rootPath, saved, total)
+	} else {
+		p.draw(false, "saving \"%s\": %d files", rootPath, saved)
+	}
+}
+
+func (p *terminalProgress) ScanFinished(rootPath string, err error) {
+	p.mu.Lock()
+	elapsed := time.Since(p.startedAt[rootPath]).Round(time.Millisecond)
+	delete(p.startedAt, rootPath)
+	p.mu.Unlock()
+
+	if err != nil {
+		p.draw(true, "scanned \"%s\" in %s with errors", rootPath, elapsed)
+	} else {
+		p.draw(true, "scanned \"%s\" in %s", rootPath, elapsed)
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	fmt.Fprintln(p.out)
+	p.width = 0
+}
+
+// Write clears the drawn line before writing, so the logs are not mixed with the progress
+func (p *terminalProgress) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.width > 0 {
+		fmt.Fprintf(p.out, "\r%s\r", strings.Repeat(" ", p.width))
+		p.width = 0
+	}
+	return p.out.Write(b)
+}
+
+// draw redraws the line, it's skipped if the last redraw is too recent unless force is true
+func (p *terminalProgress) draw(force bool, format string, args ...any) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !force && time.Since(p.drawnAt) < progressRefresh {
+		return
+	}
+	p.drawnAt = time.Now()
+
+	line := fmt.Sprintf(format, args...)
+	// the rest of a longer previous line is cleared
+	padding := ""
+	if len(line) < p.width {
+		padding = strings.Repeat(" ", p.width-len(line))
+	}
+	p.width = len(line)
+	fmt.Fprintf(p.out, "\r%s%s", line, padding)
+}
@@ -0,0 +1,145 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// cancelReader cancels the context on its nth read
+type cancelReader struct {
+	n      int
+	reads  int
+	cancel context.CancelFunc
+}
+
+func (r *cancelReader) Read(p []byte) (int, error) {
+	if r.reads++; r.reads == r.n {
+		r.cancel()
+	}
+	return len(p), nil
+}
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := &cancelReader{n: 3, cancel: cancel}
+	if _, err := io.Copy(io.Discard, &contextReader{ctx: ctx, r: r}); !errors.Is(err, context.Canceled) {
+		t.Fatalf("copy: %v", err)
+	}
+	if r.reads != 3 {
+		t.Errorf("%d reads after the cancellation", r.reads-3)
+	}
+}
+
+// hashedRecorder cancels the hashing when the first file is hashed
+type hashedRecorder struct {
+	NopProgressReporter
+	cancel context.CancelFunc
+}
+
+func (r *hashedRecorder) BytesHashed(string, int64, int64) {
+	r.cancel()
+}
+
+func TestCompareCanceled(t *testing.T) {
+	rootPath := t.TempDir()
+	for i := 0; i < 20; i++ {
+		writeFile(t, filepath.Join(rootPath, fmt.Sprint(i)), fmt.Sprint(i))
+	}
+	infos := walkOnce(t, rootPath, testOption())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	adapter := NewAdapter("md5")
+	adapter.SetProgressReporter(&hashedRecorder{cancel: cancel})
+	created, updated, deleted, moved, renamed, err := adapter.CompareContext(ctx, rootPath, infos)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("the canceled compare returns %v", err)
+	}
+	if created != nil || updated != nil || deleted != nil || moved != nil || renamed != nil {
+		t.Error("the canceled compare returns the changes")
+	}
+
+	// the file hashed before the cancellation is kept for the next compare
+	db, err := adapter.openHashingDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed, err := adapter.readFileInfos(db, adapter.pathKey(rootPath), infos.Keys())
+	db.Close()
+	if err != nil || hashed.Len() != 1 {
+		t.Fatalf("%d files are kept in the hashing db: %v", hashed.Len(), err)
+	}
+
+	adapter.SetProgressReporter(nil)
+	if created, _, _, _, _, err = adapter.Compare(rootPath, walkOnce(t, rootPath, testOption())); err != nil || created.Len() != 20 {
+		t.Fatalf("%d files are created: %v", created.Len(), err)
+	}
+	for _, info := range created {
+		if len(info.FileHashSum) == 0 {
+			t.Errorf("%s is not hashed", info.Path())
+		}
+	}
+}
+
+// a huge file is not hashed to the end after the deadline, and the saved file list is not changed
+func TestWatchDeadline(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a"), "a")
+	writeFile(t, filepath.Join(rootPath, "b"), "b")
+	adapter := NewAdapter("md5")
+	w := NewWatcher(adapter)
+	watch := watchAgain(w)
+	if err := w.Add(rootPath, testOption()); err != nil {
+		t.Fatal(err)
+	}
+	watch(t)
+	want := savedFiles(t, adapter, rootPath)
+
+	huge := filepath.Join(rootPath, "huge")
+	file, err := os.Create(huge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = file.Truncate(4 << 30)
+	file.Close()
+	if err != nil {
+		t.Skip("the sparse file could not be created:", err)
+	}
+	writeFile(t, filepath.Join(rootPath, "a"), "aa")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	begin := time.Now()
+	if err = w.WatchContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("the watch is not stopped by the deadline: %v", err)
+	}
+	if elapsed := time.Since(begin); elapsed > 2*time.Second {
+		t.Errorf("the watch is stopped %s after the deadline", elapsed)
+	}
+	got := savedFiles(t, adapter, rootPath)
+	if !reflect.DeepEqual(relNames(rootPath, got), relNames(rootPath, want)) {
+		t.Fatalf("saved %v, want %v", relNames(rootPath, got), relNames(rootPath, want))
+	}
+	saved, _ := got.Get(filepath.Join(rootPath, "a"))
+	if old, _ := want.Get(filepath.Join(rootPath, "a")); !bytes.Equal(saved.FileHashSum, old.FileHashSum) {
+		t.Error("the changed file is saved")
+	}
+
+	// the changes are reported by the next watch
+	if err = os.Remove(huge); err != nil {
+		t.Fatal(err)
+	}
+	events := watch(t)
+	if len(events) != 1 || events[0].Op != Write || events[0].Path != filepath.Join(rootPath, "a") {
+		t.Errorf("unexpected events: %v", events)
+	}
+}
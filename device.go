@@ -0,0 +1,25 @@
+//go:build !windows
+// +build !windows
+
+package watcher
+
+import (
+	"os"
+	"syscall"
+)
+
+// deviceID returns the id of the device containing the file, false if it's unknown, such as a saved info
+func deviceID(info os.FileInfo) (uint64, bool) {
+	if fi, ok := info.(*FileInfo); ok {
+		info = fi.FileInfo
+	}
+	if info == nil {
+		return 0, false
+	}
+
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, false
+	}
+	return uint64(stat.Dev), true
+}
@@ -0,0 +1,8 @@
+package watcher
+
+import "os"
+
+// deviceID returns false, the device ids are not compared on Windows
+func deviceID(info os.FileInfo) (uint64, bool) {
+	return 0, false
+}
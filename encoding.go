@@ -0,0 +1,230 @@
+package watcher
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/bytedance/sonic"
+	"time"
+)
+
+// schemaVersion is the version of the values in the db, it's saved in the setting of a root path.
+// 0: FileInfo and snapshot entries are stored as json
+// 1: FileInfo and snapshot entries are stored in the compact binary encoding
+const schemaVersion = 1
+
+// the first byte of an encoded value, json values always start with '{'
+const (
+	fileInfoEncoding      byte = 0x01
+	snapshotEntryEncoding byte = 0x02
+)
+
+const (
+	fileInfoFlagPath byte = 1 << iota // the path is different from the key, e.g. the key is lowercase on Windows
+)
+
+const (
+	snapshotEntryFlagInfo byte = 1 << iota
+	snapshotEntryFlagPrev
+)
+
+var errCorruptedValue = errors.New("error: corrupted value")
+
+// encodeFileInfo encodes the file info to the compact binary layout:
+//
+//	encoding(1) flags(1) [path] name size(varint) mode(uvarint) hash mtime-sec(varint) mtime-nsec(uvarint)
+//	dir-entries(uvarint) link-target
+//
+// the strings and bytes are prefixed with the length in uvarint, the path is omitted if it's equal to the key.
+// New fields are only appended, so the older decoders skip them.
+func encodeFileInfo(key []byte, info *FileInfo) []byte {
+	buf := make([]byte, 0, 2+len(info.FileName)+len(info.FileHashSum)+len(info.LinkTarget)+5*binary.MaxVarintLen64)
+	var flags byte
+	if info.FilePath != string(key) {
+		flags |= fileInfoFlagPath
+	}
+
+	buf = append(buf, fileInfoEncoding, flags)
+	if flags&fileInfoFlagPath != 0 {
+		buf = appendBytes(buf, []byte(info.FilePath))
+	}
+	buf = appendBytes(buf, []byte(info.FileName))
+	buf = binary.AppendVarint(buf, info.FileSize)
+	buf = binary.AppendUvarint(buf, uint64(info.FileMode))
+	buf = appendBytes(buf, info.FileHashSum)
+	buf = binary.AppendVarint(buf, info.FileMtime.Unix())
+	buf = binary.AppendUvarint(buf, uint64(info.FileMtime.Nanosecond()))
+	buf = binary.AppendUvarint(buf, uint64(info.DirEntries))
+	buf = appendBytes(buf, []byte(info.LinkTarget))
+	return buf
+}
+
+// decodeFileInfo decodes the file info of the key, the value could be json or the binary encoding
+func decodeFileInfo(key, value []byte) (*FileInfo, error) {
+	if len(value) == 0 {
+		return nil, errCorruptedValue
+	}
+
+	if value[0] == '{' {
+		var info *FileInfo
+		if err := sonic.Unmarshal(value, &info); err != nil {
+			return nil, err
+		}
+		return info, nil
+	}
+
+	if value[0] != fileInfoEncoding || len(value) < 2 {
+		return nil, errCorruptedValue
+	}
+
+	d := &decoder{buf: value[2:]}
+	info := &FileInfo{FilePath: string(key)}
+	if value[1]&fileInfoFlagPath != 0 {
+		info.FilePath = string(d.bytes())
+	}
+	info.FileName = string(d.bytes())
+	info.FileSize = d.varint()
+	info.FileMode = uint32(d.uvarint())
+	info.FileHashSum = d.bytes()
+	sec := d.varint()
+	nsec := d.uvarint()
+	info.FileMtime = time.Unix(sec, int64(nsec))
+	// the fields appended later
+	if d.more() {
+		info.DirEntries = int(d.uvarint())
+	}
+	if d.more() {
+		info.LinkTarget = string(d.bytes())
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return info, nil
+}
+
+// encodeSnapshotEntry encodes the snapshot entry to the compact binary layout:
+//
+//	encoding(1) flags(1) op(uvarint) old-path [info] [prev]
+//
+// the info and prev are encoded FileInfo prefixed with the length in uvarint
+func encodeSnapshotEntry(key []byte, entry *snapshotEntry) []byte {
+	var flags byte
+	if entry.Info != nil {
+		flags |= snapshotEntryFlagInfo
+	}
+	if entry.Prev != nil {
+		flags |= snapshotEntryFlagPrev
+	}
+
+	buf := []byte{snapshotEntryEncoding, flags}
+	buf = binary.AppendUvarint(buf, uint64(entry.Op))
+	buf = appendBytes(buf, []byte(entry.OldPath))
+	if entry.Info != nil {
+		buf = appendBytes(buf, encodeFileInfo(key, entry.Info))
+	}
+	if entry.Prev != nil {
+		buf = appendBytes(buf, encodeFileInfo(key, entry.Prev))
+	}
+	return buf
+}
+
+// decodeSnapshotEntry decodes the snapshot entry of the key, the value could be json or the binary encoding
+func decodeSnapshotEntry(key, value []byte) (*snapshotEntry, error) {
+	if len(value) == 0 {
+		return nil, errCorruptedValue
+	}
+
+	if value[0] == '{' {
+		var entry *snapshotEntry
+		if err := sonic.Unmarshal(value, &entry); err != nil {
+			return nil, err
+		}
+		return entry, nil
+	}
+
+	if value[0] != snapshotEntryEncoding || len(value) < 2 {
+		return nil, errCorruptedValue
+	}
+
+	var err error
+	d := &decoder{buf: value[2:]}
+	entry := &snapshotEntry{}
+	entry.Op = Op(d.uvarint())
+	entry.OldPath = string(d.bytes())
+	if value[1]&snapshotEntryFlagInfo != 0 {
+		if entry.Info, err = decodeFileInfo(key, d.bytes()); err != nil {
+			return nil, err
+		}
+	}
+	if value[1]&snapshotEntryFlagPrev != 0 {
+		if entry.Prev, err = decodeFileInfo(key, d.bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	if d.err != nil {
+		return nil, d.err
+	}
+	return entry, nil
+}
+
+func appendBytes(buf []byte, b []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b)))
+	return append(buf, b...)
+}
+
+// decoder reads the values in order, the error is kept and the following reads return zero values
+type decoder struct {
+	buf []byte
+	err error
+}
+
+// more returns true if there are more values to read
+func (d *decoder) more() bool {
+	return d.err == nil && len(d.buf) > 0
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = errCorruptedValue
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(d.buf)
+	if n <= 0 {
+		d.err = errCorruptedValue
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+// bytes reads a length-prefixed bytes, nil if the length is 0
+func (d *decoder) bytes() []byte {
+	l := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if uint64(len(d.buf)) < l {
+		d.err = errCorruptedValue
+		return nil
+	}
+	if l == 0 {
+		return nil
+	}
+	b := make([]byte, l)
+	copy(b, d.buf[:l])
+	d.buf = d.buf[l:]
+	return b
+}
@@ -0,0 +1,193 @@
+package watcher
+
+import (
+	"bytes"
+	"github.com/bytedance/sonic"
+	"testing"
+	"time"
+)
+
+// testFileInfo is a typical regular file of a source tree
+func testFileInfo() (key []byte, info *FileInfo) {
+	path := "/data/projects/some-project/src/module/pkg/file_test.go"
+	return []byte(path), &FileInfo{
+		FileName:    "file_test.go",
+		FilePath:    path,
+		FileSize:    12345,
+		FileMode:    0644,
+		FileHashSum: bytes.Repeat([]byte{0xab}, 16),
+		FileMtime:   time.Unix(1700000000, 123456789),
+	}
+}
+
+func equalFileInfo(a, b *FileInfo) bool {
+	return a.FileName == b.FileName && a.FilePath == b.FilePath && a.FileSize == b.FileSize && a.FileMode == b.FileMode &&
+		bytes.Equal(a.FileHashSum, b.FileHashSum) && a.FileMtime.Equal(b.FileMtime) && a.DirEntries == b.DirEntries &&
+		a.LinkTarget == b.LinkTarget
+}
+
+func TestFileInfoEncoding(t *testing.T) {
+	key, regular := testFileInfo()
+	tests := []struct {
+		name string
+		key  []byte
+		info *FileInfo
+	}{
+		{"regular", key, regular},
+		{"path differs from the key", []byte("/data/a.txt"), &FileInfo{FileName: "A.txt", FilePath: "/data/A.txt", FileSize: 1, FileMode: 0600, FileMtime: time.Unix(1, 0)}},
+		{"directory", []byte("/data/dir"), &FileInfo{FileName: "dir", FilePath: "/data/dir", FileMode: uint32(0755 | 1<<31), FileMtime: time.Unix(1700000000, 0), DirEntries: 42}},
+		{"symlink", []byte("/data/link"), &FileInfo{FileName: "link", FilePath: "/data/link", FileMode: uint32(0777 | 1<<27), FileMtime: time.Unix(1700000000, 1), LinkTarget: "../target"}},
+		{"zero", []byte("/data/zero"), &FileInfo{FileName: "zero", FilePath: "/data/zero"}},
+		{"negative size and mtime", []byte("/n"), &FileInfo{FileName: "n", FilePath: "/n", FileSize: -1, FileMtime: time.Unix(-100, 5)}},
+	}
+
+	for _, tt := range tests {
+		value := encodeFileInfo(tt.key, tt.info)
+		if value[0] != fileInfoEncoding {
+			t.Errorf("%s: encoded as %#x", tt.name, value[0])
+		}
+		decoded, err := decodeFileInfo(tt.key, value)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		} else if !equalFileInfo(decoded, tt.info) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, decoded, tt.info)
+		}
+
+		// the legacy json values are still read
+		j, err := sonic.Marshal(tt.info)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if j[0] != '{' {
+			t.Fatalf("%s: the json value starts with %q", tt.name, j[0])
+		}
+		if decoded, err = decodeFileInfo(tt.key, j); err != nil {
+			t.Errorf("%s: json: %v", tt.name, err)
+		} else if !equalFileInfo(decoded, tt.info) {
+			t.Errorf("%s: json: got %+v, want %+v", tt.name, decoded, tt.info)
+		}
+	}
+}
+
+func TestFileInfoEncodingCompatible(t *testing.T) {
+	key, info := testFileInfo()
+	info.DirEntries = 3
+	info.LinkTarget = "target"
+	value := encodeFileInfo(key, info)
+
+	// the values written before the appended fields
+	short := encodeFileInfo(key, &FileInfo{FileName: info.FileName, FilePath: info.FilePath, FileSize: info.FileSize,
+		FileMode: info.FileMode, FileHashSum: info.FileHashSum, FileMtime: info.FileMtime})
+	short = short[:len(short)-2]
+	decoded, err := decodeFileInfo(key, short)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.DirEntries != 0 || decoded.LinkTarget != "" || !decoded.FileMtime.Equal(info.FileMtime) {
+		t.Errorf("the value without the appended fields: %+v", decoded)
+	}
+
+	// the fields appended by a newer version are skipped
+	decoded, err = decodeFileInfo(key, append(bytes.Clone(value), 0x01, 0x02))
+	if err != nil || !equalFileInfo(decoded, info) {
+		t.Errorf("the value with unknown fields: %+v, %v", decoded, err)
+	}
+}
+
+func TestFileInfoEncodingCorrupted(t *testing.T) {
+	key, info := testFileInfo()
+	value := encodeFileInfo(key, info)
+
+	for name, v := range map[string][]byte{
+		"empty":     {},
+		"unknown":   {0x7f, 0x00},
+		"only head": {fileInfoEncoding},
+		"truncated": value[:len(value)/2],
+		"bad json":  []byte("{\"name\":"),
+	} {
+		if decoded, err := decodeFileInfo(key, v); err == nil {
+			t.Errorf("%s: decoded %+v", name, decoded)
+		}
+	}
+}
+
+func TestSnapshotEntryEncoding(t *testing.T) {
+	key, info := testFileInfo()
+	prev := *info
+	prev.FileSize = 1
+
+	for _, entry := range []*snapshotEntry{
+		{Op: Create, Info: info},
+		{Op: Write, Info: info, Prev: &prev},
+		{Op: Remove, Prev: &prev},
+		{Op: Move, OldPath: "/data/old/file_test.go", Info: info},
+		{Op: Move, Prev: &prev},
+	} {
+		for _, value := range [][]byte{encodeSnapshotEntry(key, entry), mustMarshal(t, entry)} {
+			decoded, err := decodeSnapshotEntry(key, value)
+			if err != nil {
+				t.Fatalf("%s: %v", entry.Op, err)
+			}
+			if decoded.Op != entry.Op || decoded.OldPath != entry.OldPath ||
+				(decoded.Info == nil) != (entry.Info == nil) || (decoded.Prev == nil) != (entry.Prev == nil) ||
+				(entry.Info != nil && !equalFileInfo(decoded.Info, entry.Info)) ||
+				(entry.Prev != nil && !equalFileInfo(decoded.Prev, entry.Prev)) {
+				t.Errorf("%s: got %+v, want %+v", entry.Op, decoded, entry)
+			}
+		}
+	}
+
+	value := encodeSnapshotEntry(key, &snapshotEntry{Op: Write, Info: info, Prev: &prev})
+	if _, err := decodeSnapshotEntry(key, value[:len(value)-3]); err == nil {
+		t.Error("the truncated entry is decoded")
+	}
+}
+
+func mustMarshal(t *testing.T, v any) []byte {
+	t.Helper()
+	j, err := sonic.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return j
+}
+
+// go test -run '^$' -bench FileInfo -benchmem
+func BenchmarkEncodeFileInfo(b *testing.B) {
+	key, info := testFileInfo()
+
+	b.Run("binary", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			size = len(encodeFileInfo(key, info))
+		}
+		b.ReportMetric(float64(size), "bytes/value")
+	})
+	b.Run("json", func(b *testing.B) {
+		var size int
+		for i := 0; i < b.N; i++ {
+			j, _ := sonic.Marshal(info)
+			size = len(j)
+		}
+		b.ReportMetric(float64(size), "bytes/value")
+	})
+}
+
+func BenchmarkDecodeFileInfo(b *testing.B) {
+	key, info := testFileInfo()
+	j, err := sonic.Marshal(info)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for name, value := range map[string][]byte{"binary": encodeFileInfo(key, info), "json": j} {
+		value := value
+		b.Run(name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := decodeFileInfo(key, value); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
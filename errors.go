@@ -1,6 +1,9 @@
 package watcher
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 var (
 	// ErrDurationTooShort occurs when calling the watcher's Start
@@ -19,4 +22,80 @@ var (
 	// ErrSkip is less of an error, but more of a way for path hooks to skip a file or
 	// directory.
 	ErrSkip = errors.New("error: skipping file")
+
+	// ErrSnapshotNotFound occurs when the snapshot id is not recorded or has been pruned.
+	ErrSnapshotNotFound = errors.New("error: snapshot not found")
+
+	// ErrConsumerNotFound occurs when acknowledging the journal with an unregistered consumer.
+	ErrConsumerNotFound = errors.New("error: journal consumer not found")
+
+	// ErrTooManyDeleted occurs when more files of a root path would be reported deleted
+	// than WatchOption.MaxDeleteCount or MaxDeletePercent allows, the changes of the root path are not saved.
+	ErrTooManyDeleted = errors.New("error: too many files would be deleted")
+
+	// ErrNotSuspect occurs when confirming the deletion of a root path which is not suspect.
+	ErrNotSuspect = errors.New("error: root path is not suspect")
+
+	// ErrNotMountPoint occurs when confirming the deletion of a path which is not a saved mount point of the root path.
+	ErrNotMountPoint = errors.New("error: path is not a saved mount point")
+)
+
+// ErrorClass is the class of the errors occurred while watching, it decides the ErrorPolicy
+type ErrorClass int
+
+const (
+	// ErrorClassWalk is the error of listing the files of a root path
+	ErrorClassWalk ErrorClass = iota
+	// ErrorClassHash is the error of hashing a file
+	ErrorClassHash
+	// ErrorClassDB is the error of reading or writing the db files
+	ErrorClassDB
 )
+
+var ErrorClasses = map[ErrorClass]string{
+	ErrorClassWalk: "walk",
+	ErrorClassHash: "hash",
+	ErrorClassDB:   "db",
+}
+
+// String prints the string version of the ErrorClass consts
+func (c ErrorClass) String() string {
+	if class, found := ErrorClasses[c]; found {
+		return class
+	}
+	return "???"
+}
+
+// ErrorPolicy decides what to do when an error of a class occurs
+type ErrorPolicy int
+
+const (
+	// Continue records the error and continues, the failed file or root path is skipped
+	Continue ErrorPolicy = iota
+	// Abort stops watching and returns the error
+	Abort
+)
+
+// Error is an error occurred on a path of a root path
+type Error struct {
+	Class ErrorClass
+	Root  string
+	Path  string
+	Op    string // the operation failed, e.g. "open db", "hash"
+	Err   error
+}
+
+func newError(class ErrorClass, root, path, op string, err error) *Error {
+	return &Error{Class: class, Root: root, Path: path, Op: op, Err: err}
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" || e.Path == e.Root {
+		return fmt.Sprintf("%s of \"%s\" error: %s", e.Op, e.Root, e.Err)
+	}
+	return fmt.Sprintf("%s \"%s\" of \"%s\" error: %s", e.Op, e.Path, e.Root, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
@@ -0,0 +1,90 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"go.uber.org/multierr"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestErrorFormat(t *testing.T) {
+	err := newError(ErrorClassHash, "/root", "/root/a.txt", "hash", os.ErrPermission)
+	if err.Error() != `hash "/root/a.txt" of "/root" error: permission denied` {
+		t.Errorf("unexpected message: %s", err)
+	}
+	if !errors.Is(err, os.ErrPermission) {
+		t.Error("the error is not unwrapped")
+	}
+
+	var watchErr *Error
+	if combined := multierr.Append(errors.New("other"), err); !errors.As(combined, &watchErr) || watchErr.Class != ErrorClassHash {
+		t.Error("the combined error is not an *Error")
+	}
+	if got := summarizeErrors(multierr.Combine(err, err, newError(ErrorClassDB, "/root", "", "open db", os.ErrExist))); got != "3 errors (db: 1, hash: 2)" {
+		t.Errorf("unexpected summary: %s", got)
+	}
+}
+
+func TestHashErrorPolicy(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "a")
+	writeFile(t, filepath.Join(rootPath, "c.txt"), "c")
+	infos := walkOnce(t, rootPath, testOption())
+	// a file removed after walking
+	missing := filepath.Join(rootPath, "b.txt")
+	infos.Put(missing, &FileInfo{FileName: "b.txt", FilePath: missing, FileSize: 1, FileMode: 0644})
+
+	adapter := NewAdapter("md5")
+	err := adapter.hashing(context.Background(), rootPath, infos)
+	var watchErr *Error
+	if !errors.As(err, &watchErr) || watchErr.Class != ErrorClassHash || watchErr.Path != missing || !os.IsNotExist(watchErr.Err) {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if adapter.aborted(err) {
+		t.Error("the error aborts with the Continue policy")
+	}
+	for path, info := range infos {
+		if hashed := len(info.FileHashSum) > 0; hashed == (path == formatPath(missing)) {
+			t.Errorf("%s is hashed: %v", path, hashed)
+		}
+	}
+
+	adapter.SetErrorPolicy(ErrorClassHash, Abort)
+	if err = adapter.hashing(context.Background(), rootPath, infos); !adapter.aborted(err) {
+		t.Errorf("the error does not abort with the Abort policy: %v", err)
+	}
+}
+
+func TestDBErrorPolicy(t *testing.T) {
+	base := t.TempDir()
+	bad, good := filepath.Join(base, "a"), filepath.Join(base, "b")
+	writeFile(t, filepath.Join(good, "x"), "x")
+	// the db could not be opened
+	if err := os.MkdirAll(filepath.Join(bad, DBFile), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, policy := range []ErrorPolicy{Continue, Abort} {
+		if err := os.RemoveAll(filepath.Join(good, DBFile)); err != nil {
+			t.Fatal(err)
+		}
+		adapter := NewAdapter("md5")
+		adapter.SetErrorPolicy(ErrorClassDB, policy)
+		w := NewWatcher(adapter)
+		_ = w.Add(bad, testOption())
+		_ = w.Add(good, testOption())
+
+		err := w.Watch()
+		var watchErr *Error
+		if !errors.As(err, &watchErr) || watchErr.Class != ErrorClassDB || watchErr.Root != bad || !strings.Contains(err.Error(), "open db") {
+			t.Fatalf("policy %d: unexpected error: %v", policy, err)
+		}
+		// the other root path is still scanned unless aborted
+		if saved := adapter.setting(good) != nil; saved != (policy == Continue) {
+			t.Errorf("policy %d: the other root path is saved: %v", policy, saved)
+		}
+	}
+}
@@ -8,16 +8,21 @@ import (
 // changes occur. It includes the os.FileInfo of the changed file or
 // directory and the type of event that's occurred and the full path of the file.
 type Event struct {
-	Op
-	Path      string `yaml:"path"`
-	OldPath   string `yaml:"old_path"`
-	*FileInfo `yaml:"-"`
+	Op        `json:"op"`
+	Path      string `yaml:"path" json:"path"`
+	OldPath   string `yaml:"old_path" json:"old_path,omitempty"`
+	*FileInfo `yaml:"-" json:"info,omitempty"`
 }
 
+// EventHandler is called with every event emitted by the Watcher
+type EventHandler func(event Event)
+
 // String returns a string depending on what type of event occurred and the
 // file name associated with the event.
 func (e Event) String() string {
-	if e.FileInfo == nil {
+	if e.Op == RootSuspect || e.Op == RootRemoved {
+		return fmt.Sprintf("ROOT %s [%s]", e.Op, e.Path)
+	} else if e.FileInfo == nil {
 		return "???"
 	}
 
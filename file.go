@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/samber/lo"
 	"os"
+	"sort"
 	"time"
 )
 
@@ -13,6 +14,7 @@ type fileStats struct {
 	DirCount  int64 `yaml:"dir_count" json:"dir_count"`
 	LinkCount int64 `yaml:"link_count" json:"link_count"`
 	TotalSize int64 `yaml:"total_size" json:"total_size"`
+	Filtered  int64 `yaml:"filtered" json:"filtered"` // the count of the files skipped by WatchOption.Filter
 }
 
 type FileInfo struct {
@@ -22,6 +24,8 @@ type FileInfo struct {
 	FileMode    uint32    `yaml:"mode" json:"mode"`
 	FileHashSum []byte    `yaml:"hash_sum" json:"hash_sum"`
 	FileMtime   time.Time `yaml:"mtime" json:"mtime"`
+	DirEntries  int       `yaml:"entries" json:"entries,omitempty"`         // the count of the children in the file list of a directory
+	LinkTarget  string    `yaml:"link_target" json:"link_target,omitempty"` // the target of a symbolic link if it's recorded or followed
 
 	os.FileInfo `yaml:"-" json:"-"`
 }
@@ -60,6 +64,17 @@ func (fi *FileInfo) HasSysFileInfo() bool {
 	return fi.FileInfo != nil
 }
 
+// changeOf returns the change of the file since the old info: Write if the content is changed or a link is retargeted,
+// Chmod if only the mode is changed, 0 if not changed. The scans, Compare, Save and the snapshots all use it.
+func (fi *FileInfo) changeOf(old *FileInfo) Op {
+	if !fi.FileMtime.Equal(old.FileMtime) || fi.FileSize != old.FileSize || fi.LinkTarget != old.LinkTarget {
+		return Write
+	} else if fi.FileMode != old.FileMode {
+		return Chmod
+	}
+	return 0
+}
+
 type FileInfos map[string]*FileInfo
 
 func NewFileInfos() FileInfos {
@@ -109,6 +124,13 @@ func (fis FileInfos) Keys() []string {
 	})
 }
 
+// SortedKeys returns the sorted keys of the file info map
+func (fis FileInfos) SortedKeys() []string {
+	keys := fis.Keys()
+	sort.Strings(keys)
+	return keys
+}
+
 // Values returns the values of the file info map
 func (fis FileInfos) Values() []*FileInfo {
 	return lo.MapToSlice(fis, func(key string, value *FileInfo) *FileInfo {
@@ -120,21 +142,37 @@ func (fis FileInfos) Values() []*FileInfo {
 func (fis FileInfos) stats() fileStats {
 	var stats fileStats
 	for _, fi := range fis {
-		if fi.IsDir() {
-			stats.DirCount++
-		} else if fi.Mode()&os.ModeSymlink != 0 {
-			stats.LinkCount++
-		} else {
-			stats.FileCount++
-			stats.TotalSize += fi.Size()
-		}
+		stats.add(fi)
 	}
 
 	return stats
 }
 
-// convertToFileInfo converts os.FileInfo to *FileInfo
+// add counts the file info to the stats
+func (s *fileStats) add(fi *FileInfo) {
+	if fi.IsDir() {
+		s.DirCount++
+	} else if fi.Mode()&os.ModeSymlink != 0 {
+		s.LinkCount++
+	} else {
+		s.FileCount++
+		s.TotalSize += fi.Size()
+	}
+}
+
+// count returns the count of all entries
+func (s fileStats) count() int64 {
+	return s.FileCount + s.DirCount + s.LinkCount
+}
+
+// convertToFileInfo converts os.FileInfo to *FileInfo, a *FileInfo is copied
 func convertToFileInfo(path string, fi os.FileInfo) *FileInfo {
+	if info, ok := fi.(*FileInfo); ok {
+		clone := *info
+		clone.FilePath = path
+		return &clone
+	}
+
 	return &FileInfo{
 		FileInfo:  fi,
 		FilePath:  path,
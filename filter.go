@@ -0,0 +1,96 @@
+package watcher
+
+import (
+	"os"
+	"time"
+)
+
+// FileType is the type of a file for FileFilter.Types
+type FileType uint32
+
+// FileTypes
+const (
+	TypeRegular FileType = 1 << iota
+	TypeDir
+	TypeSymlink
+	TypeDevice
+	TypeSocket
+	TypeNamedPipe
+
+	AllTypes FileType = TypeRegular | TypeDir | TypeSymlink | TypeDevice | TypeSocket | TypeNamedPipe
+)
+
+var FileTypes = map[FileType]string{
+	TypeRegular:   "REGULAR",
+	TypeDir:       "DIR",
+	TypeSymlink:   "SYMLINK",
+	TypeDevice:    "DEVICE",
+	TypeSocket:    "SOCKET",
+	TypeNamedPipe: "PIPE",
+	AllTypes:      "ALL",
+}
+
+// String prints the string version of the FileType consts
+func (t FileType) String() string {
+	if name, found := FileTypes[t]; found {
+		return name
+	}
+	return "???"
+}
+
+// fileTypeOf returns the type of the file mode, 0 for the irregular files
+func fileTypeOf(mode os.FileMode) FileType {
+	switch {
+	case mode.IsRegular():
+		return TypeRegular
+	case mode.IsDir():
+		return TypeDir
+	case mode&os.ModeSymlink != 0:
+		return TypeSymlink
+	case mode&os.ModeDevice != 0:
+		return TypeDevice
+	case mode&os.ModeSocket != 0:
+		return TypeSocket
+	case mode&os.ModeNamedPipe != 0:
+		return TypeNamedPipe
+	}
+	return 0
+}
+
+// FileFilter skips the files by their metadata while walking, so they are never hashed. The zero value filters nothing.
+// The directories are only filtered by Types, and a filtered directory is still walked into.
+type FileFilter struct {
+	// MinSize and MaxSize are the range of the size of the regular files, 0 for no limit
+	MinSize int64
+	MaxSize int64
+	// ModifiedAfter and ModifiedBefore are the range of the mtime of the files, zero for no limit
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// MaxDepth is the max depth of the files, the children of the root path are at depth 1, 0 for no limit
+	MaxDepth int
+	// Types is the types of the files to keep, 0 for all types
+	Types FileType
+	// ExcludeEmpty skips the empty regular files
+	ExcludeEmpty bool
+}
+
+// filtered returns true if the file should not be in the file list
+func (f *FileFilter) filtered(info os.FileInfo) bool {
+	if f.Types != 0 && f.Types&fileTypeOf(info.Mode()) == 0 {
+		return true
+	} else if info.IsDir() {
+		return false
+	}
+
+	if info.Mode().IsRegular() {
+		if (f.MinSize > 0 && info.Size() < f.MinSize) || (f.MaxSize > 0 && info.Size() > f.MaxSize) {
+			return true
+		}
+		if f.ExcludeEmpty && info.Size() == 0 {
+			return true
+		}
+	}
+
+	return (!f.ModifiedAfter.IsZero() && !info.ModTime().After(f.ModifiedAfter)) ||
+		(!f.ModifiedBefore.IsZero() && !info.ModTime().Before(f.ModifiedBefore))
+}
@@ -0,0 +1,98 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestFileFilter(t *testing.T) {
+	now := time.Now()
+	regular := &FileInfo{FileSize: 4, FileMode: 0644, FileMtime: now}
+	empty := &FileInfo{FileMode: 0644, FileMtime: now}
+	dir := &FileInfo{FileMode: uint32(os.ModeDir | 0755), FileMtime: now}
+	link := &FileInfo{FileSize: 100, FileMode: uint32(os.ModeSymlink | 0777), FileMtime: now}
+
+	tests := []struct {
+		name   string
+		filter FileFilter
+		info   *FileInfo
+		want   bool
+	}{
+		{"zero", FileFilter{}, regular, false},
+		{"min size", FileFilter{MinSize: 5}, regular, true},
+		{"max size", FileFilter{MaxSize: 3}, regular, true},
+		{"in size range", FileFilter{MinSize: 4, MaxSize: 4}, regular, false},
+		{"size of a link", FileFilter{MaxSize: 3}, link, false},
+		{"empty", FileFilter{ExcludeEmpty: true}, empty, true},
+		{"not empty", FileFilter{ExcludeEmpty: true}, regular, false},
+		{"modified after", FileFilter{ModifiedAfter: now}, regular, true},
+		{"modified before", FileFilter{ModifiedBefore: now}, regular, true},
+		{"in mtime range", FileFilter{ModifiedAfter: now.Add(-time.Hour), ModifiedBefore: now.Add(time.Hour)}, regular, false},
+		{"type", FileFilter{Types: TypeRegular}, link, true},
+		{"types", FileFilter{Types: TypeRegular | TypeSymlink}, link, false},
+		{"directory by type", FileFilter{Types: TypeRegular}, dir, true},
+		// the directories are only filtered by Types
+		{"directory by size", FileFilter{MinSize: 5, ModifiedBefore: now}, dir, false},
+	}
+	for _, tt := range tests {
+		if got := tt.filter.filtered(tt.info); got != tt.want {
+			t.Errorf("%s: filtered %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestFilterWalk(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "big"), "0123456789")
+	writeFile(t, filepath.Join(rootPath, "small"), "01")
+	writeFile(t, filepath.Join(rootPath, "empty"), "")
+	writeFile(t, filepath.Join(rootPath, "d1", "f"), "0123")
+	writeFile(t, filepath.Join(rootPath, "d1", "d2", "f"), "0123")
+	writeFile(t, filepath.Join(rootPath, "d1", "d2", "d3", "f"), "0123")
+	writeFile(t, filepath.Join(rootPath, "old"), "0123")
+	old := time.Now().Add(-48 * time.Hour)
+	if err := os.Chtimes(filepath.Join(rootPath, "old"), old, old); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("big", filepath.Join(rootPath, "link")); err != nil {
+		t.Skip("symlinks are not supported:", err)
+	}
+
+	tests := []struct {
+		filter   FileFilter
+		want     []string
+		filtered int64
+	}{
+		{FileFilter{MinSize: 3, MaxSize: 5}, []string{"d1", "d1/d2", "d1/d2/d3", "d1/d2/d3/f", "d1/d2/f", "d1/f", "link", "old"}, 3},
+		{FileFilter{ExcludeEmpty: true}, []string{"big", "d1", "d1/d2", "d1/d2/d3", "d1/d2/d3/f", "d1/d2/f", "d1/f", "link", "old", "small"}, 1},
+		{FileFilter{MaxDepth: 2}, []string{"big", "d1", "d1/d2", "d1/f", "empty", "link", "old", "small"}, 0},
+		{FileFilter{MaxDepth: 1}, []string{"big", "d1", "empty", "link", "old", "small"}, 0},
+		// the filtered directories are walked into
+		{FileFilter{Types: TypeRegular}, []string{"big", "d1/d2/d3/f", "d1/d2/f", "d1/f", "empty", "old", "small"}, 4},
+		{FileFilter{Types: TypeSymlink | TypeDir, MaxDepth: 1}, []string{"d1", "link"}, 4},
+		{FileFilter{ModifiedBefore: time.Now().Add(-time.Hour), Types: TypeRegular}, []string{"old"}, 10},
+	}
+	for _, tt := range tests {
+		option := testOption()
+		option.Filter, option.Parallelism = tt.filter, 3
+
+		adapter := NewAdapter("md5")
+		sc, err := adapter.beginScan(rootPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewWatcher(adapter).walk(context.Background(), sc, rootPath, option)
+		sc.close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got := relNames(rootPath, sc.created); !reflect.DeepEqual(got, tt.want) || sc.stats.Filtered != tt.filtered {
+			t.Errorf("%+v: walked %v and filtered %d, want %v and %d", tt.filter, got, sc.stats.Filtered, tt.want, tt.filtered)
+		}
+	}
+}
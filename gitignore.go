@@ -54,9 +54,11 @@ The summarized version of the same has been copied here:
 
 import (
 	"io/ioutil"
+	"log"
 	"os"
 	"regexp"
 	"strings"
+	"unicode/utf8"
 )
 
 ////////////////////////////////////////////////////////////
@@ -70,81 +72,177 @@ type IgnoreParser interface {
 ////////////////////////////////////////////////////////////
 
 // This function pretty much attempts to mimic the parsing rules
-// listed above at the start of this file
-func getPatternFromLine(line string) (*regexp.Regexp, bool) {
+// listed above at the start of this file, err is the error of a pattern which could not be compiled
+func getPatternFromLine(line string) (pattern *regexp.Regexp, negatePattern bool, dirOnly bool, err error) {
 	// Trim OS-specific carriage returns.
 	line = strings.TrimRight(line, "\r")
 
 	// Strip comments [Rule 2]
 	if strings.HasPrefix(line, `#`) {
-		return nil, false
+		return nil, false, false, nil
 	}
 
-	// Trim string [Rule 3]
-	// TODO: Handle [Rule 3], when the " " is escaped with a \
-	line = strings.Trim(line, " ")
+	// Trim the trailing spaces unless they are escaped with a \ [Rule 3]
+	line = trimTrailingSpaces(line)
 
 	// Exit for no-ops and return nil which will prevent us from
 	// appending a pattern against this line
 	if line == "" {
-		return nil, false
+		return nil, false, false, nil
 	}
 
-	// TODO: Handle [Rule 4] which negates the match for patterns leading with "!"
-	negatePattern := false
+	// Handle [Rule 4] which negates the match for patterns leading with "!",
+	// the escaped \# and \! are unescaped as the other escaped chars
 	if line[0] == '!' {
 		negatePattern = true
 		line = line[1:]
 	}
 
-	// Handle [Rule 2, 4], when # or ! is escaped with a \
-	// Handle [Rule 4] once we tag negatePattern, strip the leading ! char
-	if regexp.MustCompile(`^(\#|\!)`).MatchString(line) {
-		line = line[1:]
+	// A trailing slash only matches a directory [Rule 5]
+	if strings.HasSuffix(line, "/") {
+		dirOnly = true
+		line = strings.TrimSuffix(line, "/")
 	}
 
-	// If we encounter a foo/*.blah in a folder, prepend the / char
-	if regexp.MustCompile(`([^\/+])/.*\*\.`).MatchString(line) && line[0] != '/' {
-		line = "/" + line
+	// A pattern without a slash matches at any level, otherwise it's relative to the location of the file,
+	// a leading slash only anchors the pattern [Rule 6, 7, 8]
+	anchored := strings.Contains(line, "/")
+	line = strings.TrimPrefix(line, "/")
+	if line == "" {
+		return nil, false, false, nil
 	}
 
-	// Handle escaping the "." char
-	line = regexp.MustCompile(`\.`).ReplaceAllString(line, `\.`)
+	var expr strings.Builder
+	expr.WriteString("^")
+	if !anchored {
+		expr.WriteString("(?:.*/)?")
+	}
 
-	magicStar := "#$~"
+	// Handle the "**" segments [Rule 9]
+	segments := strings.Split(line, "/")
+	for i, segment := range segments {
+		first, last := i == 0, i == len(segments)-1
+		if segment == "**" {
+			switch {
+			case first && last:
+				expr.WriteString(".*")
+			case last:
+				// "abc/**" matches everything inside "abc", but not itself
+				expr.WriteString(".+")
+			default:
+				// "**/foo" and "a/**/b" match zero or more directories
+				expr.WriteString("(?:.*/)?")
+			}
+			continue
+		}
 
-	// Handle "/**/" usage
-	if strings.HasPrefix(line, "/**/") {
-		line = line[1:]
+		expr.WriteString(segmentToExpr(segment))
+		if !last {
+			expr.WriteString("/")
+		}
 	}
-	line = regexp.MustCompile(`/\*\*/`).ReplaceAllString(line, `(/|/.+/)`)
-	line = regexp.MustCompile(`\*\*/`).ReplaceAllString(line, `(|.`+magicStar+`/)`)
-	line = regexp.MustCompile(`/\*\*`).ReplaceAllString(line, `(|/.`+magicStar+`)`)
 
-	// Handle escaping the "*" char
-	line = regexp.MustCompile(`\\\*`).ReplaceAllString(line, `\`+magicStar)
-	line = regexp.MustCompile(`\*`).ReplaceAllString(line, `([^/]*)`)
+	expr.WriteString("$")
 
-	// Handle escaping the "?" char
-	line = strings.Replace(line, "?", `\?`, -1)
+	if pattern, err = regexp.Compile(expr.String()); err != nil {
+		return nil, false, false, err
+	}
+	return pattern, negatePattern, dirOnly, nil
+}
 
-	line = strings.Replace(line, magicStar, "*", -1)
+// trimTrailingSpaces removes the trailing spaces which are not escaped with a backslash
+func trimTrailingSpaces(line string) string {
+	for strings.HasSuffix(line, " ") {
+		trimmed := strings.TrimSuffix(line, " ")
+		backslashes := len(trimmed) - len(strings.TrimRight(trimmed, `\`))
+		if backslashes%2 == 1 {
+			break
+		}
+		line = trimmed
+	}
+	return line
+}
 
-	// Temporary regex
-	var expr = ""
-	if strings.HasSuffix(line, "/") {
-		expr = line + "(|.*)$"
-	} else {
-		expr = line + "(|/.*)$"
+// segmentToExpr converts a glob between slashes to a regular expression, the wildcards do not match a slash.
+// "*" matches any string, "?" matches any char, "[...]" matches a char in the class, "[!...]" negates it,
+// and a backslash escapes the next char.
+func segmentToExpr(segment string) string {
+	glob := []rune(segment)
+	var expr strings.Builder
+	for i := 0; i < len(glob); i++ {
+		switch c := glob[i]; c {
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+			}
+			expr.WriteString(regexp.QuoteMeta(string(glob[i])))
+		case '*':
+			expr.WriteString("[^/]*")
+		case '?':
+			expr.WriteString("[^/]")
+		case '[':
+			if class, n := classToExpr(glob[i:]); n > 0 {
+				expr.WriteString(class)
+				i += n - 1
+			} else {
+				expr.WriteString(`\[`)
+			}
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	return expr.String()
+}
+
+// classToExpr converts the character class at the start of the glob, it returns the count of the runes of the class
+// in the glob, 0 if the class is not closed
+func classToExpr(glob []rune) (string, int) {
+	i := 1
+	negate := false
+	if i < len(glob) && (glob[i] == '!' || glob[i] == '^') {
+		negate = true
+		i++
 	}
-	if strings.HasPrefix(expr, "/") {
-		expr = "^(|/)" + expr[1:]
-	} else {
-		expr = "^(|.*/)" + expr
+
+	var class strings.Builder
+	for start := i; i < len(glob); i++ {
+		c := glob[i]
+		switch {
+		case c == ']' && i > start:
+			if negate {
+				return "[^/" + class.String() + "]", i + 1
+			}
+			return "[" + class.String() + "]", i + 1
+		case c == '[' && i+1 < len(glob) && glob[i+1] == ':':
+			// the POSIX classes, such as [:alpha:], are the same in the regular expression
+			end := i + 2
+			for end+1 < len(glob) && (glob[end] != ':' || glob[end+1] != ']') {
+				end++
+			}
+			if end+1 >= len(glob) {
+				class.WriteString(`\[`)
+				continue
+			}
+			class.WriteString(string(glob[i : end+2]))
+			i = end + 1
+		case c == '\\' && i+1 < len(glob):
+			// the escaped letters and digits are themselves, the regular expression escapes are not meant
+			if i++; isAlnum(glob[i]) || glob[i] >= utf8.RuneSelf {
+				class.WriteRune(glob[i])
+			} else {
+				class.WriteString(`\` + string(glob[i]))
+			}
+		case c == '\\' || c == '[' || c == ']' || c == '^':
+			class.WriteString(`\` + string(c))
+		default:
+			class.WriteRune(c)
+		}
 	}
-	pattern, _ := regexp.Compile(expr)
+	return "", 0
+}
 
-	return pattern, negatePattern
+func isAlnum(c rune) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
 }
 
 ////////////////////////////////////////////////////////////
@@ -155,6 +253,8 @@ type IgnorePattern struct {
 	Negate  bool
 	LineNo  int
 	Line    string
+	// DirOnly is true if the pattern ends with a slash, it only matches a directory
+	DirOnly bool
 }
 
 // GitIgnore wraps a list of ignore pattern.
@@ -168,10 +268,13 @@ type GitIgnore struct {
 func CompileIgnoreLines(lines ...string) *GitIgnore {
 	gi := &GitIgnore{}
 	for i, line := range lines {
-		pattern, negatePattern := getPatternFromLine(line)
-		if pattern != nil {
+		pattern, negatePattern, dirOnly, err := getPatternFromLine(line)
+		if err != nil {
+			// git never matches an invalid pattern
+			log.Printf("[WARN] the ignore pattern %q of line %d is skipped: %s", line, i+1, err)
+		} else if pattern != nil {
 			// LineNo is 1-based numbering to match `git check-ignore -v` output
-			ip := &IgnorePattern{pattern, negatePattern, i + 1, line}
+			ip := &IgnorePattern{pattern, negatePattern, i + 1, line, dirOnly}
 			gi.patterns = append(gi.patterns, ip)
 		}
 	}
@@ -206,33 +309,53 @@ func CompileIgnoreFileAndLines(fpath string, lines ...string) (*GitIgnore, error
 ////////////////////////////////////////////////////////////
 
 // MatchesPath returns true if the given GitIgnore structure would target
-// a given path string `f`.
+// a given path string `f`. A path with a trailing slash is a directory.
 func (gi *GitIgnore) MatchesPath(f string) bool {
 	matchesPath, _ := gi.MatchesPathHow(f)
 	return matchesPath
 }
 
 // MatchesPathHow returns true, `pattern` if the given GitIgnore structure would target
-// a given path string `f`.
-// The IgnorePattern has the Line, LineNo fields.
+// a given path string `f`. A path with a trailing slash is a directory.
+// The IgnorePattern is the last matched one which decides, it has the Line, LineNo fields.
 func (gi *GitIgnore) MatchesPathHow(f string) (bool, *IgnorePattern) {
+	mip := gi.lastMatch(f)
+	return mip != nil && !mip.Negate, mip
+}
+
+// match returns whether the path is matched by any pattern, and whether it's ignored by the last matched one
+func (gi *GitIgnore) match(f string) (matched bool, ignored bool) {
+	mip := gi.lastMatch(f)
+	return mip != nil, mip != nil && !mip.Negate
+}
+
+// lastMatch returns the pattern deciding the path, nil if no pattern matches.
+// As git does, a path in an excluded directory is excluded, it could not be re-included by a negated pattern.
+func (gi *GitIgnore) lastMatch(f string) *IgnorePattern {
 	// Replace OS-specific path separator.
 	f = strings.Replace(f, string(os.PathSeparator), "/", -1)
 
-	matchesPath := false
+	// the parent directories with the trailing slash
+	for i := 0; i < len(f)-1; i++ {
+		if f[i] != '/' {
+			continue
+		}
+		if mip := gi.lastMatchOf(f[:i+1]); mip != nil && !mip.Negate {
+			return mip
+		}
+	}
+	return gi.lastMatchOf(f)
+}
+
+func (gi *GitIgnore) lastMatchOf(f string) *IgnorePattern {
+	isDir := strings.HasSuffix(f, "/")
+	f = strings.TrimSuffix(f, "/")
+
 	var mip *IgnorePattern
 	for _, ip := range gi.patterns {
-		if ip.Pattern.MatchString(f) {
-			// If this is a regular target (not negated with a gitignore
-			// exclude "!" etc)
-			if !ip.Negate {
-				matchesPath = true
-				mip = ip
-			} else if matchesPath {
-				// Negated pattern, and matchesPath is already set
-				matchesPath = false
-			}
+		if (isDir || !ip.DirOnly) && ip.Pattern.MatchString(f) {
+			mip = ip
 		}
 	}
-	return matchesPath, mip
+	return mip
 }
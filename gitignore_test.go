@@ -0,0 +1,203 @@
+package watcher
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGitIgnore(t *testing.T) {
+	tests := []struct {
+		lines []string
+		path  string
+		want  bool
+	}{
+		// negation, a file in an excluded directory could not be re-included
+		{[]string{"*.log", "!keep.log"}, "a.log", true},
+		{[]string{"*.log", "!keep.log"}, "sub/keep.log", false},
+		{[]string{"build/", "!build/keep.txt"}, "build/keep.txt", true},
+		{[]string{"build/*", "!build/keep.txt"}, "build/keep.txt", false},
+		{[]string{`\!bang`}, "!bang", true},
+		{[]string{`\#hash`}, "#hash", true},
+		{[]string{"#hash"}, "#hash", false},
+
+		// "**"
+		{[]string{"**/foo"}, "a/b/foo", true},
+		{[]string{"**/foo"}, "foo", true},
+		{[]string{"abc/**"}, "abc/x/y", true},
+		{[]string{"abc/**"}, "abc/", false},
+		{[]string{"a/**/b"}, "a/b", true},
+		{[]string{"a/**/b"}, "a/x/y/b", true},
+		{[]string{"a/**/b"}, "x/a/b", false},
+		{[]string{"**"}, "any/path", true},
+
+		// trailing spaces
+		{[]string{"trail   "}, "trail", true},
+		{[]string{"trail   "}, "trail ", false},
+		{[]string{`trail\ `}, "trail ", true},
+		{[]string{`trail\ `}, "trail", false},
+		{[]string{"sp ace"}, "sp ace", true},
+
+		// character classes
+		{[]string{"x[0-9]"}, "x1", true},
+		{[]string{"x[0-9]"}, "xa", false},
+		{[]string{"x[!0-9]"}, "xa", true},
+		{[]string{"x[^0-9]"}, "x1", false},
+		{[]string{"x[[:digit:]]"}, "x2", true},
+		{[]string{`x\[1\]`}, "x[1]", true},
+		{[]string{`x[\-]`}, "x-", true},
+		{[]string{`x[\-]`}, "x\\", false},
+		{[]string{"x[]a]"}, "x]", true},
+		{[]string{"x[!a]"}, "x/", false},
+		{[]string{"x[a"}, "x[a", true},
+		{[]string{"q?"}, "qz", true},
+		{[]string{`q\?`}, "qz", false},
+		{[]string{`star\*`}, "star*", true},
+		{[]string{`star\*`}, "starz", false},
+
+		// the non-ASCII chars are runes, they are matched as a whole
+		{[]string{"[äö].txt"}, "ä.txt", true},
+		{[]string{"[äö].txt"}, "é.txt", false},
+		{[]string{"[!ä].txt"}, "é.txt", true},
+		{[]string{"[à-ö].txt"}, "é.txt", true},
+		{[]string{`[\é].txt`}, "é.txt", true},
+		{[]string{"?.txt"}, "é.txt", true},
+		{[]string{"日本/*.go"}, "日本/a.go", true},
+
+		// directories only
+		{[]string{"foo/"}, "foo", false},
+		{[]string{"foo/"}, "foo/", true},
+		{[]string{"foo/"}, "bar/foo/x", true},
+		{[]string{"*.d/"}, "f.d", false},
+		{[]string{"*.d/"}, "dir.d/f", true},
+
+		// anchoring
+		{[]string{"/foo"}, "foo", true},
+		{[]string{"/foo"}, "bar/foo", false},
+		{[]string{"foo"}, "bar/foo", true},
+		{[]string{"Doc/*.html"}, "Doc/a.html", true},
+		{[]string{"Doc/*.html"}, "Doc/ppc/b.html", false},
+		{[]string{"Doc/*.html"}, "tools/Doc/c.html", false},
+		{[]string{"/*.c"}, "mozilla-sha1/sha1.c", false},
+	}
+
+	for _, tt := range tests {
+		if got := CompileIgnoreLines(tt.lines...).MatchesPath(tt.path); got != tt.want {
+			t.Errorf("%q matches %q: %v, want %v", tt.lines, tt.path, got, tt.want)
+		}
+	}
+}
+
+// an invalid pattern is skipped, it does not affect the other lines
+func TestGitIgnoreInvalidPattern(t *testing.T) {
+	if _, _, _, err := getPatternFromLine("[z-a]"); err == nil {
+		t.Fatal("the invalid range is compiled")
+	}
+
+	gi := CompileIgnoreLines("[z-a]", "*.log")
+	matched, how := gi.MatchesPathHow("a.log")
+	if !matched || how.LineNo != 2 || len(gi.patterns) != 1 {
+		t.Errorf("the valid pattern is not kept: %v, %+v", matched, how)
+	}
+}
+
+// gitTree is the files to list by git with the patterns of gitCases
+var gitTree = []string{
+	"a.txt", "b.log", "keep.log", "foo", "bar/foo", "bar/baz.txt", "foo2/x.txt",
+	"build/out.o", "build/keep.txt", "src/build/y.c", "src/a/b/c.go", "src/x.go",
+	"Doc/a.html", "Doc/ppc/b.html", "tools/Doc/c.html", "abc/x/y", "abc/z",
+	"a/b", "a/x/b", "a/x/y/b", "deep/a/b/file", "trail ", "trail2 ", "#hash", "!bang",
+	"x[1]", "x1", "x2", "xa", "x-", "q?", "qz", "star*", "starz", "dir.d/f", "f.d",
+	"sp ace", "Up.TXT", "nested/dir/foo/z", "one/two/three.md", "1.md",
+}
+
+var gitCases = [][]string{
+	{"*.log", "!keep.log"},
+	{"foo"},
+	{"/foo"},
+	{"foo/"},
+	{"build/", "!build/keep.txt"},
+	{"build", "!build/keep.txt"},
+	{"build/*", "!build/keep.txt"},
+	{"/build/"},
+	{"Doc/*.html"},
+	{"**/foo"},
+	{"**/Doc/*.html"},
+	{"abc/**"},
+	{"a/**/b"},
+	{"deep/**/file"},
+	{`trail\ `},
+	{"trail2   "},
+	{`\#hash`},
+	{`\!bang`},
+	{"x[0-9]"},
+	{"x[!0-9]"},
+	{"x[a]"},
+	{`x[\-]`},
+	{`x\[1\]`},
+	{"q?"},
+	{`q\?`},
+	{`star\*`},
+	{"*.d/"},
+	{"*.d"},
+	{"sp ace"},
+	{"*.TXT"},
+	{"foo/z"},
+	{"**/dir/**/z"},
+	{"*", "!*/", "!*.md"},
+	{"/*", "!/one"},
+	{"**"},
+	{"src/**/*.go"},
+	{"*.go", "!src/a/"},
+	{"x[[:digit:]]"},
+	{"[!a-z]*"},
+	{"ab?/"},
+	{"one/**/*.md"},
+	{"*/"},
+	{"/*/"},
+	{"bar/*"},
+	{"nested/", "!nested/dir/"},
+	{"#comment", "", "   ", "a.txt"},
+	{"src/*", "!src/a"},
+}
+
+// the patterns agree with git on the ASCII paths, git matches the bytes of the non-ASCII chars
+func TestGitIgnoreAgainstGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not found")
+	}
+
+	base := t.TempDir()
+	for _, path := range gitTree {
+		writeFile(t, filepath.Join(base, path), path)
+	}
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Dir = base
+		// the user's global excludes are not applied
+		cmd.Env = append(os.Environ(), "HOME="+base, "XDG_CONFIG_HOME="+filepath.Join(base, "config"), "GIT_CONFIG_NOSYSTEM=1")
+		out, err := cmd.Output()
+		if err != nil {
+			t.Fatalf("git %s: %v", strings.Join(args, " "), err)
+		}
+		return string(out)
+	}
+	git("init", "-q")
+
+	for _, lines := range gitCases {
+		writeFile(t, filepath.Join(base, GitIgnoreFile), strings.Join(lines, "\n")+"\n")
+		listed := make(map[string]bool)
+		for _, path := range strings.Split(git("ls-files", "--others", "--exclude-standard", "-z"), "\x00") {
+			listed[path] = true
+		}
+
+		gi := CompileIgnoreLines(lines...)
+		for _, path := range gitTree {
+			if gi.MatchesPath(path) == listed[path] {
+				t.Errorf("%q: %q is listed by git: %v", lines, path, listed[path])
+			}
+		}
+	}
+}
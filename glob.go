@@ -0,0 +1,125 @@
+package watcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// globRoot is the files and the glob patterns watched in a root directory, they share the option of the last Add
+type globRoot struct {
+	paths    []string // the watched paths
+	patterns []string // the gitignore style patterns of the paths, relative to the root directory
+	option   WatchOption
+}
+
+// RootPath returns the root directory of a watched path which is a directory, a file or a glob pattern,
+// the file list of the root directory is saved in it
+func RootPath(path string) (string, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	rootPath, _, err := splitWatchPath(path)
+	return rootPath, err
+}
+
+// splitWatchPath splits an absolute watched path into the root directory and the pattern of the files under it,
+// the pattern is empty if the path is a directory. A file is its parent directory and its escaped name,
+// and a glob is its longest directory prefix without the wildcards and the rest of it.
+func splitWatchPath(path string) (rootPath string, pattern string, err error) {
+	info, err := os.Stat(path)
+	if err == nil {
+		if info.IsDir() {
+			return path, "", nil
+		}
+		return filepath.Dir(path), "/" + escapePattern(filepath.Base(path)), nil
+	} else if !os.IsNotExist(err) || !hasMeta(path) {
+		return "", "", err
+	}
+
+	for rootPath = filepath.Dir(path); hasMeta(rootPath); rootPath = filepath.Dir(rootPath) {
+	}
+	if info, err = os.Stat(rootPath); err != nil {
+		return "", "", err
+	} else if !info.IsDir() {
+		return "", "", errors.New("not a directory: " + rootPath)
+	}
+
+	relPath, _ := filepath.Rel(rootPath, path)
+	return rootPath, "/" + filepath.ToSlash(relPath), nil
+}
+
+// hasMeta returns true if the path has the wildcards of a glob
+func hasMeta(path string) bool {
+	return strings.ContainsAny(path, "*?[")
+}
+
+// escapePattern escapes a file name to a gitignore pattern which only matches the name
+func escapePattern(name string) string {
+	var sb strings.Builder
+	for i, c := range name {
+		switch {
+		case c == '*' || c == '?' || c == '[' || c == '\\':
+			sb.WriteByte('\\')
+		case i == 0 && (c == '#' || c == '!'):
+			sb.WriteByte('\\')
+		case c == ' ' && strings.TrimRight(name[i:], " ") == "":
+			sb.WriteByte('\\')
+		}
+		sb.WriteRune(c)
+	}
+	return sb.String()
+}
+
+// add adds the watched path and its pattern, the option replaces the previous one
+func (g *globRoot) add(path, pattern string, option WatchOption) {
+	g.option = option
+	for _, p := range g.paths {
+		if p == path {
+			return
+		}
+	}
+	g.paths = append(g.paths, path)
+	g.patterns = append(g.patterns, pattern)
+}
+
+// remove removes the watched path, it returns false if the path is not watched
+func (g *globRoot) remove(path string) bool {
+	for i, p := range g.paths {
+		if p == path {
+			g.paths = append(g.paths[:i], g.paths[i+1:]...)
+			g.patterns = append(g.patterns[:i], g.patterns[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
+
+// watchOption returns the option of the root directory, only the files matched by the patterns are kept,
+// and the directories are not tracked. The root directory is walked as deep as the patterns unless one has "**".
+func (g *globRoot) watchOption() WatchOption {
+	option := g.option
+	option.Include = CompileIgnoreLines(g.patterns...)
+	if option.Filter.Types == 0 {
+		option.Filter.Types = AllTypes
+	}
+	option.Filter.Types &^= TypeDir
+
+	depth := 0
+	for _, pattern := range g.patterns {
+		if strings.Contains(pattern, "**") {
+			depth = 0
+			break
+		} else if d := strings.Count(pattern, "/"); d > depth {
+			depth = d
+		}
+	}
+	option.Recursive = depth != 1
+	if depth > 1 && (option.Filter.MaxDepth <= 0 || option.Filter.MaxDepth > depth) {
+		option.Filter.MaxDepth = depth
+	}
+	return option
+}
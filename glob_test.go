@@ -0,0 +1,159 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestSplitWatchPath(t *testing.T) {
+	base := t.TempDir()
+	writeFile(t, filepath.Join(base, "single"), "s")
+	writeFile(t, filepath.Join(base, "x[1]"), "x")
+	writeFile(t, filepath.Join(base, "sub", "c.log"), "c")
+
+	tests := []struct {
+		path     string
+		rootPath string
+		pattern  string
+	}{
+		{base, base, ""},
+		{filepath.Join(base, "single"), base, "/single"},
+		// an existing file is not a glob
+		{filepath.Join(base, "x[1]"), base, `/x\[1]`},
+		{filepath.Join(base, "*.log"), base, "/*.log"},
+		{filepath.Join(base, "sub", "*", "*.log"), filepath.Join(base, "sub"), "/*/*.log"},
+		{filepath.Join(base, "s*", "c.log"), base, "/s*/c.log"},
+	}
+	for _, tt := range tests {
+		rootPath, pattern, err := splitWatchPath(tt.path)
+		if err != nil || rootPath != tt.rootPath || pattern != tt.pattern {
+			t.Errorf("%s: %s, %s, %v", tt.path, rootPath, pattern, err)
+		}
+	}
+
+	for _, path := range []string{filepath.Join(base, "nothing"), filepath.Join(base, "nothing", "*.log"), filepath.Join(base, "single", "*")} {
+		if _, _, err := splitWatchPath(path); err == nil {
+			t.Errorf("%s is split", path)
+		}
+	}
+}
+
+func TestEscapePattern(t *testing.T) {
+	for _, name := range []string{"a.log", "x[1]", "a*b?", `back\slash`, "#hash", "!bang", "trail  ", "a b", "日本"} {
+		pattern := escapePattern(name)
+		if gi := CompileIgnoreLines("/" + pattern); !gi.MatchesPath(name) {
+			t.Errorf("%q escaped as %q does not match itself", name, pattern)
+		}
+	}
+	if gi := CompileIgnoreLines("/" + escapePattern("a*")); gi.MatchesPath("ab") {
+		t.Error("the escaped wildcard matches")
+	}
+}
+
+func TestWatchGlobs(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.log"), "a")
+	writeFile(t, filepath.Join(rootPath, "b.txt"), "b")
+	writeFile(t, filepath.Join(rootPath, "single"), "s")
+	writeFile(t, filepath.Join(rootPath, "x[1]"), "x")
+	writeFile(t, filepath.Join(rootPath, "sub", "c.log"), "c")
+	writeFile(t, filepath.Join(rootPath, "sub", "deep", "d.log"), "d")
+
+	adapter := NewAdapter("md5")
+	w := NewWatcher(adapter)
+	watch := watchAgain(w)
+	option := WatchOption{Op: All}
+	for _, path := range []string{"*.log", "single", "x[1]", "*/deep/*.log"} {
+		if err := w.Add(filepath.Join(rootPath, path), option); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Add(filepath.Join(rootPath, "nothing"), option); err == nil {
+		t.Fatal("the missing file is added")
+	}
+	// the files and the globs share the root directory
+	if got := w.RootPaths(); !reflect.DeepEqual(got, []string{rootPath}) {
+		t.Fatalf("root paths %v", got)
+	}
+
+	names := func(events []Event) []string {
+		var names []string
+		for _, event := range events {
+			rel, _ := filepath.Rel(rootPath, event.Path)
+			names = append(names, event.Op.String()+" "+filepath.ToSlash(rel))
+		}
+		sort.Strings(names)
+		return names
+	}
+	// the directories and the db are not tracked
+	if got, want := names(watch(t)), []string{"CREATE a.log", "CREATE single", "CREATE sub/deep/d.log", "CREATE x[1]"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	if _, err := os.Stat(filepath.Join(rootPath, DBFile)); err != nil {
+		t.Errorf("the db is not in the root directory: %v", err)
+	}
+
+	if err := os.Remove(filepath.Join(rootPath, "single")); err != nil {
+		t.Fatal(err)
+	}
+	writeFile(t, filepath.Join(rootPath, "e.log"), "e")
+	writeFile(t, filepath.Join(rootPath, "f.txt"), "f")
+	if got, want := names(watch(t)), []string{"CREATE e.log", "REMOVE single"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	if got := relNames(rootPath, savedFiles(t, adapter, rootPath)); !reflect.DeepEqual(got, []string{"a.log", "e.log", "sub/deep/d.log", "x[1]"}) {
+		t.Errorf("saved %v", got)
+	}
+
+	// the root directory is removed with its last watched path
+	for _, path := range []string{"*.log", "*/deep/*.log", "x[1]"} {
+		w.Remove(filepath.Join(rootPath, path))
+	}
+	if len(w.RootPaths()) != 1 {
+		t.Fatal("the root directory is removed with its paths left")
+	}
+	w.Remove(filepath.Join(rootPath, "single"))
+	if len(w.RootPaths()) != 0 {
+		t.Fatal("the root directory is not removed")
+	}
+}
+
+// the files and the globs added while watching are scanned against the saved file list of their root directory
+func TestAddSavedGlobs(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.log"), "a")
+	writeFile(t, filepath.Join(rootPath, "b.txt"), "b")
+	writeFile(t, filepath.Join(rootPath, "single"), "s")
+	paths := []string{filepath.Join(rootPath, "*.log"), filepath.Join(rootPath, "single")}
+
+	newWatcher := func(adapter *Adapter) func(t *testing.T) []Event {
+		w := NewWatcher(adapter)
+		for _, path := range paths {
+			if err := w.Add(path, WatchOption{Op: All}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return watchAgain(w)
+	}
+	if events := newWatcher(NewAdapter("md5"))(t); len(events) != 2 {
+		t.Fatalf("events %v", events)
+	}
+
+	adapter := NewAdapter("md5")
+	for _, event := range newWatcher(adapter)(t) {
+		t.Errorf("unexpected event of the saved files: %s", event)
+	}
+	snapshots, err := adapter.Snapshots(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[1].Initial {
+		t.Fatalf("the scan is recorded as %+v", snapshots[len(snapshots)-1])
+	}
+	if got := relNames(rootPath, savedFiles(t, adapter, rootPath)); !reflect.DeepEqual(got, []string{"a.log", "single"}) {
+		t.Errorf("saved %v", got)
+	}
+}
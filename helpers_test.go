@@ -0,0 +1,118 @@
+package watcher
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"testing"
+)
+
+// writeFile writes the file and creates its parent directories
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// relNames returns the sorted paths of the infos relative to the root path
+func relNames(rootPath string, infos FileInfos) []string {
+	names := make([]string, 0, len(infos))
+	for _, info := range infos {
+		rel, _ := filepath.Rel(rootPath, info.Path())
+		names = append(names, filepath.ToSlash(rel))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// testOption is a recursive option of all ops which ignores the db file
+func testOption() WatchOption {
+	return WatchOption{Recursive: true, Op: All, Ignore: CompileIgnoreLines(DBFile)}
+}
+
+// watchOnce loads the root path, watches it once with the option and returns the emitted events
+func watchOnce(t *testing.T, adapter *Adapter, rootPath string, option WatchOption) []Event {
+	t.Helper()
+	if err := adapter.LoadAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+
+	var events []Event
+	w := NewWatcher(adapter)
+	w.OnEvent(func(event Event) {
+		events = append(events, event)
+	})
+	if err := w.Add(rootPath, option); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+// watchAgain returns a func which watches the root paths of the watcher once and returns the events emitted by it
+func watchAgain(w *Watcher) func(t *testing.T) []Event {
+	var mu sync.Mutex
+	var events []Event
+	w.OnEvent(func(event Event) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, event)
+	})
+
+	return func(t *testing.T) []Event {
+		t.Helper()
+		mu.Lock()
+		events = nil
+		mu.Unlock()
+		if err := w.Watch(); err != nil {
+			t.Fatal(err)
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		return events
+	}
+}
+
+// walkOnce walks the root path with the option without saving, it returns the walked files
+func walkOnce(t *testing.T, rootPath string, option WatchOption) FileInfos {
+	t.Helper()
+	adapter := NewAdapter("md5")
+	// the root path is loaded as never saved, so every walked file is created
+	adapter.settings[formatPath(rootPath)] = nil
+	sc, err := adapter.beginScan(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.close()
+
+	if err = NewWatcher(adapter).walk(context.Background(), sc, rootPath, option); err != nil {
+		t.Fatal(err)
+	}
+	return sc.created
+}
+
+// savedFiles reads the saved file list of the root path from its db
+func savedFiles(t *testing.T, adapter *Adapter, rootPath string) FileInfos {
+	t.Helper()
+	defer adapter.lockRoot(rootPath)()
+
+	db, err := adapter.openDB(adapter.getDbPath(rootPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	infos, err := adapter.readAllFileInfos(db, adapter.pathKey(rootPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return infos
+}
@@ -0,0 +1,34 @@
+package watcher
+
+import (
+	"io/fs"
+	"path/filepath"
+	"strings"
+)
+
+// fileAttributeHidden is FILE_ATTRIBUTE_HIDDEN of Windows
+const fileAttributeHidden = 0x2
+
+// HiddenChecker returns true if the file is hidden, the entry is stat-ed only if the checker needs its info
+type HiddenChecker func(path string, entry fs.DirEntry) (bool, error)
+
+// DotFileHidden is the hidden checker of Unix, a file is hidden if its name starts with a dot
+func DotFileHidden(path string, entry fs.DirEntry) (bool, error) {
+	return strings.HasPrefix(filepath.Base(path), "."), nil
+}
+
+// AttributeHidden returns the hidden checker of Windows, a file is hidden if it has the hidden attribute.
+// attributes returns the attributes of the file, nil for reading them from the file system, which only works on Windows.
+func AttributeHidden(attributes func(path string, entry fs.DirEntry) (uint32, error)) HiddenChecker {
+	if attributes == nil {
+		attributes = fileAttributes
+	}
+
+	return func(path string, entry fs.DirEntry) (bool, error) {
+		attrs, err := attributes(path, entry)
+		if err != nil {
+			return false, err
+		}
+		return attrs&fileAttributeHidden != 0, nil
+	}
+}
@@ -0,0 +1,101 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// fakeAttributes are the attributes of Windows, the files of the names are hidden
+func fakeAttributes(names ...string) func(path string, entry fs.DirEntry) (uint32, error) {
+	return func(path string, entry fs.DirEntry) (uint32, error) {
+		for _, name := range names {
+			if entry.Name() == name {
+				return fileAttributeHidden | 0x20, nil
+			}
+		}
+		// FILE_ATTRIBUTE_ARCHIVE
+		return 0x20, nil
+	}
+}
+
+func TestIgnoreHidden(t *testing.T) {
+	// the root path itself is hidden, only its children are checked
+	rootPath := filepath.Join(t.TempDir(), ".root")
+	for _, path := range []string{"a", ".env", ".cache/x", "d/.hid", "d/y", "w/attr", "w/plain"} {
+		writeFile(t, filepath.Join(rootPath, path), path)
+	}
+
+	windows := AttributeHidden(fakeAttributes("attr", "d"))
+	tests := []struct {
+		name   string
+		option WatchOption
+		want   []string
+	}{
+		{"not ignored", WatchOption{}, []string{".cache", ".cache/x", ".env", "a", "d", "d/.hid", "d/y", "w", "w/attr", "w/plain"}},
+		{"hidden", WatchOption{IgnoreHidden: true, HiddenChecker: DotFileHidden},
+			[]string{"a", "d", "d/y", "w", "w/attr", "w/plain"}},
+		{"hidden files", WatchOption{IgnoreHiddenFiles: true, HiddenChecker: DotFileHidden},
+			[]string{".cache", ".cache/x", "a", "d", "d/y", "w", "w/attr", "w/plain"}},
+		{"hidden dirs", WatchOption{IgnoreHiddenDirs: true, HiddenChecker: DotFileHidden},
+			[]string{".env", "a", "d", "d/.hid", "d/y", "w", "w/attr", "w/plain"}},
+		// the dot files are not hidden on Windows
+		{"attribute hidden", WatchOption{IgnoreHidden: true, HiddenChecker: windows},
+			[]string{".cache", ".cache/x", ".env", "a", "w", "w/plain"}},
+		{"attribute hidden files", WatchOption{IgnoreHiddenFiles: true, HiddenChecker: windows},
+			[]string{".cache", ".cache/x", ".env", "a", "d", "d/.hid", "d/y", "w", "w/plain"}},
+		{"attribute hidden dirs", WatchOption{IgnoreHiddenDirs: true, HiddenChecker: windows},
+			[]string{".cache", ".cache/x", ".env", "a", "w", "w/attr", "w/plain"}},
+	}
+	for _, tt := range tests {
+		option := tt.option
+		option.Recursive, option.Ignore = true, CompileIgnoreLines(DBFile)
+		if got := relNames(rootPath, walkOnce(t, rootPath, option)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: walked %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// the checker is called only if it's needed, and its error fails the walk
+func TestHiddenCheckerError(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "d", "a"), "a")
+
+	var checked []string
+	errAttributes := errors.New("attributes")
+	option := testOption()
+	option.IgnoreHiddenDirs = true
+	option.HiddenChecker = AttributeHidden(func(path string, entry fs.DirEntry) (uint32, error) {
+		checked = append(checked, entry.Name())
+		return 0, errAttributes
+	})
+
+	adapter := NewAdapter("md5")
+	sc, err := adapter.beginScan(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.close()
+	if err = NewWatcher(adapter).walk(context.Background(), sc, rootPath, option); !errors.Is(err, errAttributes) {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if !reflect.DeepEqual(checked, []string{"d"}) {
+		t.Errorf("checked %v", checked)
+	}
+}
+
+func TestDotFileHidden(t *testing.T) {
+	for path, want := range map[string]bool{
+		"a":                        false,
+		".a":                       true,
+		filepath.Join(".dir", "a"): false,
+		filepath.Join("dir", ".a"): true,
+	} {
+		if got, _ := DotFileHidden(path, nil); got != want {
+			t.Errorf("%s is hidden: %v", path, got)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package watcher
+
+import (
+	bolt "go.etcd.io/bbolt"
+	"path/filepath"
+	"time"
+)
+
+// FileRevision is a recorded change of a file
+type FileRevision struct {
+	SnapshotID uint64    `yaml:"snapshot_id" json:"snapshot_id"`
+	At         time.Time `yaml:"at" json:"at"`
+	Op         Op        `yaml:"op" json:"op"`
+	Path       string    `yaml:"path" json:"path"`
+	OldPath    string    `yaml:"old_path" json:"old_path,omitempty"` // the file was moved or renamed from
+	Info       *FileInfo `yaml:"info" json:"info,omitempty"`         // nil if the file was removed or moved away
+}
+
+// FileHistory returns the revisions of the path from the newest to the oldest, following the moves and renames.
+// The path could be absolute or relative to the root path.
+// The last revision is the state of the file as of the oldest snapshot if the file existed then,
+// its Op is Create if the snapshot is the initial scan, otherwise the Op is 0 for the earlier history has been pruned.
+func (s *Adapter) FileHistory(rootPath, path string) ([]*FileRevision, error) {
+	defer s.lockRoot(rootPath)()
+
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(rootPath, path)
+	}
+
+	db, err := s.openDB(s.getDbPath(rootPath))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var revisions []*FileRevision
+	err = db.View(func(tx *bolt.Tx) error {
+		revisions, err = s.readFileHistory(tx, s.pathKey(rootPath), formatPath(path))
+		return err
+	})
+	return revisions, err
+}
+
+func (s *Adapter) readFileHistory(tx *bolt.Tx, keyName []byte, path string) ([]*FileRevision, error) {
+	snapshots, err := s.readSnapshots(tx, keyName)
+	if err != nil || len(snapshots) == 0 {
+		return nil, err
+	}
+
+	var revisions []*FileRevision
+	// the state of the tracked path before the oldest visited revision
+	bucket, err := s.activeFileBucket(tx, keyName)
+	if err != nil {
+		return nil, err
+	}
+	before, err := s.readFileInfo(bucket, path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(snapshots) - 1; i >= 0; i-- {
+		snapshot := snapshots[i]
+		entry, err := s.readSnapshotEntry(tx, keyName, snapshot.ID, path)
+		if err != nil {
+			return nil, err
+		} else if entry == nil {
+			continue
+		}
+
+		revisions = append(revisions, &FileRevision{
+			SnapshotID: snapshot.ID,
+			At:         snapshot.At,
+			Op:         entry.Op,
+			Path:       path,
+			OldPath:    entry.OldPath,
+			Info:       entry.Info,
+		})
+		before = entry.Prev
+
+		// follow the file to the path it was moved or renamed from
+		if entry.OldPath != "" {
+			path = entry.OldPath
+			if source, err := s.readSnapshotEntry(tx, keyName, snapshot.ID, path); err != nil {
+				return nil, err
+			} else if source != nil {
+				before = source.Prev
+			}
+		}
+	}
+
+	if before != nil {
+		oldest := snapshots[0]
+		revision := &FileRevision{SnapshotID: oldest.ID, At: oldest.At, Path: path, Info: before}
+		if oldest.Initial {
+			revision.Op = Create
+		}
+		revisions = append(revisions, revision)
+	}
+
+	return revisions, nil
+}
+
+// readSnapshotEntry reads the entry of the path in a snapshot, nil if the path is not changed in it
+func (s *Adapter) readSnapshotEntry(tx *bolt.Tx, keyName []byte, id uint64, path string) (*snapshotEntry, error) {
+	bucket := s.nestedBucket(tx, []byte(snapshotDeltaBucket), keyName, itob(id))
+	if bucket == nil {
+		return nil, nil
+	}
+
+	v := bucket.Get(s.pathKey(path))
+	if v == nil {
+		return nil, nil
+	}
+
+	return decodeSnapshotEntry(s.pathKey(path), v)
+}
+
+// readFileInfo reads the file info of the path in the bucket, nil if not exists
+func (s *Adapter) readFileInfo(bucket *bolt.Bucket, path string) (*FileInfo, error) {
+	if bucket == nil {
+		return nil, nil
+	}
+
+	v := bucket.Get(s.pathKey(path))
+	if v == nil {
+		return nil, nil
+	}
+
+	return decodeFileInfo(s.pathKey(path), v)
+}
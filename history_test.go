@@ -0,0 +1,79 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileHistory(t *testing.T) {
+	rootPath := t.TempDir()
+	adapter := NewAdapter("md5")
+
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "a")
+	watchOnce(t, adapter, rootPath, testOption())
+	time.Sleep(10 * time.Millisecond)
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "aa")
+	watchOnce(t, adapter, rootPath, testOption())
+
+	// moved to another directory, then renamed and written
+	if err := os.MkdirAll(filepath.Join(rootPath, "d"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(filepath.Join(rootPath, "a.txt"), filepath.Join(rootPath, "d", "a.txt")); err != nil {
+		t.Fatal(err)
+	}
+	watchOnce(t, adapter, rootPath, testOption())
+	if err := os.Rename(filepath.Join(rootPath, "d", "a.txt"), filepath.Join(rootPath, "d", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	watchOnce(t, adapter, rootPath, testOption())
+	time.Sleep(10 * time.Millisecond)
+	writeFile(t, filepath.Join(rootPath, "d", "b.txt"), "bbb")
+	watchOnce(t, adapter, rootPath, testOption())
+
+	revisions, err := adapter.FileHistory(rootPath, filepath.Join("d", "b.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		id   uint64
+		op   Op
+		path string
+		size int64
+	}{
+		{5, Write, "d/b.txt", 3},
+		{4, Rename, "d/b.txt", 2},
+		{3, Move, "d/a.txt", 2},
+		{2, Write, "a.txt", 2},
+		{1, Create, "a.txt", 1},
+	}
+	if len(revisions) != len(want) {
+		t.Fatalf("got %d revisions, want %d", len(revisions), len(want))
+	}
+	for i, w := range want {
+		r := revisions[i]
+		if r.SnapshotID != w.id || r.Op != w.op || r.Path != formatPath(filepath.Join(rootPath, w.path)) ||
+			r.Info == nil || r.Info.FileSize != w.size {
+			t.Errorf("revision %d: got #%d %s %s, want #%d %s %s", i, r.SnapshotID, r.Op, r.Path, w.id, w.op, w.path)
+		}
+	}
+	if revisions[2].OldPath != formatPath(filepath.Join(rootPath, "a.txt")) {
+		t.Errorf("the move is from %s", revisions[2].OldPath)
+	}
+
+	// the pruned history ends with the state as of the oldest snapshot
+	adapter.SetSnapshotRetention(SnapshotRetention{KeepLast: 2})
+	if err = os.Remove(filepath.Join(rootPath, "d", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	watchOnce(t, adapter, rootPath, testOption())
+	if revisions, err = adapter.FileHistory(rootPath, filepath.Join(rootPath, "d", "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if len(revisions) != 3 || revisions[0].Op != Remove || revisions[0].Info != nil || revisions[2].Op != 0 || revisions[2].SnapshotID != 5 {
+		t.Fatalf("unexpected revisions of the pruned history: %d", len(revisions))
+	}
+}
@@ -0,0 +1,292 @@
+package watcher
+
+import (
+	"bufio"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The per-directory ignore files honored by WatchOption.IgnoreFiles,
+// the patterns in .watchignore take precedence over the ones in .gitignore of the same directory
+const (
+	GitIgnoreFile   = ".gitignore"
+	WatchIgnoreFile = ".watchignore"
+)
+
+// ignoreFileNames is the ignore files of a directory in the order of precedence
+var ignoreFileNames = []string{WatchIgnoreFile, GitIgnoreFile}
+
+// ignoreFileCache is the compiled ignore files, a file is compiled again when its mtime or size is changed
+type ignoreFileCache struct {
+	mu    sync.Mutex
+	files map[string]*ignoreFile
+}
+
+type ignoreFile struct {
+	modTime time.Time
+	size    int64
+	ignore  *GitIgnore
+}
+
+func newIgnoreFileCache() *ignoreFileCache {
+	return &ignoreFileCache{files: make(map[string]*ignoreFile)}
+}
+
+// load returns the compiled ignore file, nil if it does not exist.
+// changed is true if the file is changed since it was loaded before, a file loaded the first time is not changed.
+func (c *ignoreFileCache) load(path string) (gi *GitIgnore, changed bool, err error) {
+	stat, err := os.Stat(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return nil, false, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	cached, ok := c.files[path]
+	if err != nil || stat.IsDir() {
+		// removed
+		delete(c.files, path)
+		return nil, ok, nil
+	} else if ok && cached.modTime.Equal(stat.ModTime()) && cached.size == stat.Size() {
+		return cached.ignore, false, nil
+	}
+
+	if gi, err = CompileIgnoreFile(path); err != nil {
+		return nil, false, err
+	}
+	c.files[path] = &ignoreFile{modTime: stat.ModTime(), size: stat.Size(), ignore: gi}
+	return gi, ok, nil
+}
+
+// ignoreRules is the ignore files applied to the children of a directory, the patterns of a file match the paths
+// relative to the directory of the file. The rules are linked to the ones of the parent directory up to the top of
+// the repository, or the root path if it's not in a repository. Like git, the rules of the outer repository are not
+// applied to a nested one.
+type ignoreRules struct {
+	parent *ignoreRules
+	dir    string
+	// .watchignore and .gitignore of the directory
+	files []*GitIgnore
+	// .git/info/exclude and the global excludes file, only for the top
+	excludes []*GitIgnore
+	// any of the files is changed since the last scan, inherited by the children
+	changed bool
+}
+
+// match returns whether the path is matched by any rule and whether it's ignored.
+// The files of a deeper directory take precedence over the ones of its parents, then the excludes of the top,
+// and the last matched pattern in a file decides.
+func (r *ignoreRules) match(path string, isDir bool) (matched bool, ignored bool) {
+	top := r
+	for ; r != nil; r = r.parent {
+		top = r
+		if matched, ignored = matchIgnores(r.files, r.dir, path, isDir); matched {
+			return
+		}
+	}
+	return matchIgnores(top.excludes, top.dir, path, isDir)
+}
+
+func matchIgnores(ignores []*GitIgnore, dir string, path string, isDir bool) (matched bool, ignored bool) {
+	if len(ignores) == 0 {
+		return false, false
+	}
+
+	relPath, err := filepath.Rel(dir, path)
+	if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") {
+		return false, false
+	}
+	for _, gi := range ignores {
+		if matched, ignored = gi.match(ignorePath(relPath, isDir)); matched {
+			return
+		}
+	}
+	return false, false
+}
+
+// ignorePath returns the path matched by the patterns, a directory has a trailing slash
+func ignorePath(relPath string, isDir bool) string {
+	if isDir {
+		return relPath + string(filepath.Separator)
+	}
+	return relPath
+}
+
+// childRules returns the rules of a directory from the names of its entries, the rules of the parent are returned
+// if the directory has no ignore file. A directory with ".git" is the top of a repository.
+func (c *ignoreFileCache) childRules(parent *ignoreRules, dir string, names map[string]bool, globalExcludes *GitIgnore) (*ignoreRules, error) {
+	rules := &ignoreRules{parent: parent, dir: dir}
+	if parent != nil {
+		rules.changed = parent.changed
+	}
+
+	if names[formatPath(".git")] {
+		rules.parent = nil
+		if gitDir := resolveGitDir(filepath.Join(dir, ".git")); gitDir != "" {
+			exclude, changed, err := c.load(filepath.Join(gitDir, "info", "exclude"))
+			if err != nil {
+				return nil, err
+			}
+			rules.changed = rules.changed || changed
+			if exclude != nil {
+				rules.excludes = append(rules.excludes, exclude)
+			}
+		}
+		if globalExcludes != nil {
+			rules.excludes = append(rules.excludes, globalExcludes)
+		}
+	}
+
+	for _, name := range ignoreFileNames {
+		if !names[formatPath(name)] {
+			continue
+		}
+		gi, changed, err := c.load(filepath.Join(dir, name))
+		if err != nil {
+			return nil, err
+		}
+		rules.changed = rules.changed || changed
+		if gi != nil {
+			rules.files = append(rules.files, gi)
+		}
+	}
+
+	if rules.parent == parent && len(rules.files) == 0 && !rules.changed {
+		return parent, nil
+	}
+	return rules, nil
+}
+
+// rootRules returns the rules applied to the root path. If the root path is inside a repository,
+// the ignore files of its parents up to the top of the repository are applied as git does.
+func (c *ignoreFileCache) rootRules(rootPath string, globalExcludes *GitIgnore) (*ignoreRules, error) {
+	var parents []string
+	for dir := filepath.Dir(rootPath); ; dir = filepath.Dir(dir) {
+		parents = append(parents, dir)
+		if _, err := os.Lstat(filepath.Join(dir, ".git")); err == nil {
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			// not in a repository
+			parents = nil
+			break
+		}
+	}
+
+	// the global excludes file is applied to the root path if it's not in a repository
+	rules := &ignoreRules{dir: rootPath}
+	if globalExcludes != nil {
+		rules.excludes = []*GitIgnore{globalExcludes}
+	}
+	if len(parents) == 0 {
+		return rules, nil
+	}
+
+	rules = nil
+	for i := len(parents) - 1; i >= 0; i-- {
+		names := make(map[string]bool)
+		for _, name := range append([]string{".git"}, ignoreFileNames...) {
+			if _, err := os.Lstat(filepath.Join(parents[i], name)); err == nil {
+				names[formatPath(name)] = true
+			}
+		}
+
+		var err error
+		if rules, err = c.childRules(rules, parents[i], names, globalExcludes); err != nil {
+			return nil, err
+		}
+	}
+	return rules, nil
+}
+
+// resolveGitDir returns the git directory of ".git", which is a file with "gitdir: <path>" in a worktree or a submodule
+func resolveGitDir(dotGit string) string {
+	stat, err := os.Stat(dotGit)
+	if err != nil {
+		return ""
+	} else if stat.IsDir() {
+		return dotGit
+	}
+
+	content, err := os.ReadFile(dotGit)
+	if err != nil {
+		return ""
+	}
+	gitDir, ok := strings.CutPrefix(strings.TrimSpace(string(content)), "gitdir:")
+	if !ok {
+		return ""
+	}
+	gitDir = strings.TrimSpace(gitDir)
+	if !filepath.IsAbs(gitDir) {
+		gitDir = filepath.Join(filepath.Dir(dotGit), gitDir)
+	}
+	return gitDir
+}
+
+// globalExcludesFile returns the path of git's global excludes file, the core.excludesFile of the git config,
+// or $XDG_CONFIG_HOME/git/ignore by default
+func globalExcludesFile() string {
+	home, _ := os.UserHomeDir()
+	configHome := os.Getenv("XDG_CONFIG_HOME")
+	if configHome == "" && home != "" {
+		configHome = filepath.Join(home, ".config")
+	}
+
+	var configs []string
+	if configHome != "" {
+		configs = append(configs, filepath.Join(configHome, "git", "config"))
+	}
+	if home != "" {
+		configs = append(configs, filepath.Join(home, ".gitconfig"))
+	}
+
+	// the latter config takes precedence as git does
+	var excludesFile string
+	for _, config := range configs {
+		if path := readExcludesFile(config); path != "" {
+			excludesFile = path
+		}
+	}
+
+	if excludesFile == "" && configHome != "" {
+		excludesFile = filepath.Join(configHome, "git", "ignore")
+	}
+	if strings.HasPrefix(excludesFile, "~/") && home != "" {
+		excludesFile = filepath.Join(home, excludesFile[2:])
+	}
+	return excludesFile
+}
+
+// readExcludesFile reads core.excludesFile from a git config file, the includes of the config are not followed
+func readExcludesFile(config string) string {
+	f, err := os.Open(config)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	var section, excludesFile string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			section = strings.ToLower(strings.Trim(line, "[] \t"))
+			continue
+		}
+
+		key, value, ok := strings.Cut(line, "=")
+		if ok && section == "core" && strings.EqualFold(strings.TrimSpace(key), "excludesFile") {
+			excludesFile = strings.Trim(strings.TrimSpace(value), `"`)
+		}
+	}
+	return excludesFile
+}
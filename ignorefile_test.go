@@ -0,0 +1,111 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ignoreFileTree writes a repository with the ignore files of every scope
+func ignoreFileTree(t *testing.T) (base, rootPath string) {
+	base = t.TempDir()
+	rootPath = filepath.Join(base, "repo")
+	for path, content := range map[string]string{
+		".git/info/exclude": "*.exc\n",
+		".gitignore":        "*.log\nbuild/\n!keep.log\n",
+		"sub/.gitignore":    "!b.log\n*.tmp\n",
+		"sub/.watchignore":  "e.txt\n",
+		// the rules of the outer repository are not applied to a nested one
+		"nested/.git/HEAD": "ref: refs/heads/master\n",
+	} {
+		writeFile(t, filepath.Join(rootPath, path), content)
+	}
+	for _, path := range []string{"a.log", "keep.log", "a.txt", "d.tmp", "f.exc", "g.glob", "build/x.txt",
+		"sub/b.log", "sub/c.log", "sub/d.tmp", "sub/e.txt", "nested/n.log", "nested/n.exc"} {
+		// the contents differ, so the files are not detected as moved
+		writeFile(t, filepath.Join(rootPath, path), path)
+	}
+	writeFile(t, filepath.Join(base, "excludes"), "*.glob\n")
+	return base, rootPath
+}
+
+func ignoreFileOption(base string) WatchOption {
+	option := testOption()
+	option.Ignore = CompileIgnoreLines(DBFile, ".git/")
+	option.IgnoreFiles, option.ExcludesFile = true, filepath.Join(base, "excludes")
+	return option
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	base, rootPath := ignoreFileTree(t)
+	option := ignoreFileOption(base)
+
+	want := []string{".gitignore", "a.txt", "d.tmp", "keep.log", "nested", "nested/n.exc", "nested/n.log",
+		"sub", "sub/.gitignore", "sub/.watchignore", "sub/b.log"}
+	if got := relNames(rootPath, walkOnce(t, rootPath, option)); !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %v, want %v", got, want)
+	}
+
+	// the rules of the parents are applied to a root path inside the repository
+	subPath := filepath.Join(rootPath, "sub")
+	if got := relNames(subPath, walkOnce(t, subPath, option)); !reflect.DeepEqual(got, []string{".gitignore", ".watchignore", "b.log"}) {
+		t.Errorf("walked %v in the subdirectory", got)
+	}
+
+	// the patterns of WatchOption.Ignore take precedence over the ignore files
+	option.Ignore = CompileIgnoreLines(DBFile, ".git/", "!a.log", "keep.log")
+	want = []string{".gitignore", "a.log", "a.txt", "d.tmp", "nested", "nested/n.exc", "nested/n.log",
+		"sub", "sub/.gitignore", "sub/.watchignore", "sub/b.log"}
+	if got := relNames(rootPath, walkOnce(t, rootPath, option)); !reflect.DeepEqual(got, want) {
+		t.Errorf("walked %v with the patterns of the option, want %v", got, want)
+	}
+}
+
+// a changed ignore file is reloaded, even if the mtime of its directory is kept in the fast-scan mode
+func TestIgnoreFilesChanged(t *testing.T) {
+	base, rootPath := ignoreFileTree(t)
+	option := ignoreFileOption(base)
+	option.FastScan = true
+
+	adapter := NewAdapter("md5")
+	if err := adapter.LoadAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	var events []Event
+	w := NewWatcher(adapter)
+	w.OnEvent(func(event Event) {
+		events = append(events, event)
+	})
+	if err := w.Add(rootPath, option); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Watch(); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	writeFile(t, filepath.Join(rootPath, ".gitignore"), "*.log\n")
+	if err = os.Chtimes(rootPath, info.ModTime(), info.ModTime()); err != nil {
+		t.Fatal(err)
+	}
+
+	events = nil
+	if err = w.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	ops := make(map[string]Op)
+	for _, event := range events {
+		rel, _ := filepath.Rel(rootPath, event.Path)
+		ops[filepath.ToSlash(rel)] = event.Op
+	}
+	want := map[string]Op{".gitignore": Write, "build": Create, "build/x.txt": Create, "keep.log": Remove}
+	if !reflect.DeepEqual(ops, want) {
+		t.Errorf("events %v, want %v", ops, want)
+	}
+}
@@ -0,0 +1,283 @@
+package watcher
+
+import (
+	"github.com/bytedance/sonic"
+	bolt "go.etcd.io/bbolt"
+	"time"
+)
+
+// JournalEntry is an event appended to the journal, Cursor is the sequence number of it
+type JournalEntry struct {
+	Cursor uint64    `yaml:"cursor" json:"cursor"`
+	At     time.Time `yaml:"at" json:"at"`
+	Root   string    `yaml:"root" json:"root"`
+	Event  Event     `yaml:"event" json:"event"`
+}
+
+// JournalRetention is the policy of removing the entries when no consumer is registered, the zero value keeps all.
+// The entries are always kept until all registered consumers acknowledged them.
+type JournalRetention struct {
+	// KeepLast keeps the last n entries
+	KeepLast int `yaml:"keep_last" json:"keep_last"`
+	// MaxAge removes the entries older than it
+	MaxAge time.Duration `yaml:"max_age" json:"max_age"`
+}
+
+const journalDbFile = "journal.db"
+const journalBucket = "journal"
+const consumerBucket = "consumer"
+
+func (s *Adapter) openJournalDB() (*bolt.DB, error) {
+	return s.openDataDB(journalDbFile)
+}
+
+// SetJournalRetention sets the retention policy applied after every AppendEvents
+func (s *Adapter) SetJournalRetention(retention JournalRetention) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.journalRetention = retention
+}
+
+// AppendEvents appends the events of the root path to the journal,
+// the entries out of the retention are removed if no consumer is registered
+func (s *Adapter) AppendEvents(rootPath string, events ...Event) error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+
+	db, err := s.openJournalDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	now := time.Now()
+	return db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists([]byte(journalBucket))
+		if err != nil {
+			return err
+		}
+
+		for _, event := range events {
+			entry := &JournalEntry{At: now, Root: rootPath, Event: event}
+			if entry.Cursor, err = bucket.NextSequence(); err != nil {
+				return err
+			}
+
+			j, err := sonic.Marshal(entry)
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put(itob(entry.Cursor), j); err != nil {
+				return err
+			}
+		}
+		return s.compactJournal(tx)
+	})
+}
+
+// ReadEvents reads the journal entries after the cursor, at most limit entries if limit > 0
+func (s *Adapter) ReadEvents(since uint64, limit int) ([]*JournalEntry, error) {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	db, err := s.openJournalDB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var entries []*JournalEntry
+	err = db.View(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(journalBucket))
+		if bucket == nil {
+			return nil
+		}
+
+		c := bucket.Cursor()
+		for k, v := c.Seek(itob(since + 1)); k != nil && (limit <= 0 || len(entries) < limit); k, v = c.Next() {
+			var entry *JournalEntry
+			if err := sonic.Unmarshal(v, &entry); err != nil {
+				return err
+			}
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	return entries, err
+}
+
+// RegisterConsumer registers a consumer of the journal, the entries are kept until all consumers acknowledged them.
+// A new consumer starts from the current end of the journal, registering an existing consumer does nothing.
+func (s *Adapter) RegisterConsumer(name string) error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	db, err := s.openJournalDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		consumers, err := tx.CreateBucketIfNotExists([]byte(consumerBucket))
+		if err != nil {
+			return err
+		}
+		if consumers.Get([]byte(name)) != nil {
+			return nil
+		}
+
+		journal, err := tx.CreateBucketIfNotExists([]byte(journalBucket))
+		if err != nil {
+			return err
+		}
+		return consumers.Put([]byte(name), itob(journal.Sequence()))
+	})
+}
+
+// UnregisterConsumer removes the consumer, its unacknowledged entries will not be kept anymore
+func (s *Adapter) UnregisterConsumer(name string) error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	db, err := s.openJournalDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		consumers := tx.Bucket([]byte(consumerBucket))
+		if consumers == nil {
+			return nil
+		}
+		if err := consumers.Delete([]byte(name)); err != nil {
+			return err
+		}
+		return s.compactJournal(tx)
+	})
+}
+
+// ConsumerCursor returns the last acknowledged cursor of the consumer
+func (s *Adapter) ConsumerCursor(name string) (uint64, error) {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	db, err := s.openJournalDB()
+	if err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	var cursor uint64
+	err = db.View(func(tx *bolt.Tx) error {
+		consumers := tx.Bucket([]byte(consumerBucket))
+		if consumers == nil || consumers.Get([]byte(name)) == nil {
+			return ErrConsumerNotFound
+		}
+		cursor = btoi(consumers.Get([]byte(name)))
+		return nil
+	})
+	return cursor, err
+}
+
+// Ack acknowledges the entries until the cursor for the consumer,
+// the entries acknowledged by all consumers are removed from the journal
+func (s *Adapter) Ack(name string, cursor uint64) error {
+	s.journalMu.Lock()
+	defer s.journalMu.Unlock()
+
+	db, err := s.openJournalDB()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return db.Update(func(tx *bolt.Tx) error {
+		consumers := tx.Bucket([]byte(consumerBucket))
+		if consumers == nil || consumers.Get([]byte(name)) == nil {
+			return ErrConsumerNotFound
+		}
+		// the cursor never goes back
+		if cursor <= btoi(consumers.Get([]byte(name))) {
+			return nil
+		}
+		if err := consumers.Put([]byte(name), itob(cursor)); err != nil {
+			return err
+		}
+		return s.compactJournal(tx)
+	})
+}
+
+// compactJournal removes the entries acknowledged by all consumers,
+// or the entries out of the retention if there is no consumer registered
+func (s *Adapter) compactJournal(tx *bolt.Tx) error {
+	journal := tx.Bucket([]byte(journalBucket))
+	if journal == nil {
+		return nil
+	}
+
+	var acked uint64
+	var registered bool
+	if consumers := tx.Bucket([]byte(consumerBucket)); consumers != nil {
+		_ = consumers.ForEach(func(k, v []byte) error {
+			if cursor := btoi(v); !registered || cursor < acked {
+				acked = cursor
+			}
+			registered = true
+			return nil
+		})
+	}
+	if !registered {
+		acked = s.expiredJournal(journal)
+	}
+
+	// deleting in the cursor iteration skips keys, collect them first
+	var keys [][]byte
+	c := journal.Cursor()
+	for k, _ := c.First(); k != nil && btoi(k) <= acked; k, _ = c.Next() {
+		keys = append(keys, k)
+	}
+	for _, k := range keys {
+		if err := journal.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// expiredJournal returns the last cursor out of the retention, 0 if all entries are kept.
+// The entries are removed from the oldest, so the count of the entries is the range of the cursors.
+func (s *Adapter) expiredJournal(journal *bolt.Bucket) uint64 {
+	s.mu.RLock()
+	retention := s.journalRetention
+	s.mu.RUnlock()
+
+	first, _ := journal.Cursor().First()
+	if first == nil || (retention.KeepLast <= 0 && retention.MaxAge <= 0) {
+		return 0
+	}
+
+	var expired uint64
+	if last := journal.Sequence(); retention.KeepLast > 0 && last-btoi(first)+1 > uint64(retention.KeepLast) {
+		expired = last - uint64(retention.KeepLast)
+	}
+
+	if retention.MaxAge > 0 {
+		now := time.Now()
+		c := journal.Cursor()
+		for k, v := c.Seek(itob(expired + 1)); k != nil; k, v = c.Next() {
+			// an undecodable entry could not be replayed, it's removed with the expired ones
+			var entry *JournalEntry
+			if err := sonic.Unmarshal(v, &entry); err == nil && now.Sub(entry.At) <= retention.MaxAge {
+				break
+			}
+			expired = btoi(k)
+		}
+	}
+	return expired
+}
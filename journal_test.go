@@ -0,0 +1,182 @@
+package watcher
+
+import (
+	"github.com/bytedance/sonic"
+	bolt "go.etcd.io/bbolt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// newJournalAdapter returns an adapter with an empty journal, the journal db is shared by the tests
+func newJournalAdapter(t *testing.T) *Adapter {
+	t.Helper()
+	adapter := NewAdapter("md5")
+	if err := os.Remove(adapter.dataDBPath(journalDbFile)); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = os.Remove(adapter.dataDBPath(journalDbFile))
+	})
+	return adapter
+}
+
+// the data directory is created accessible to its owner
+func TestOpenDataDB(t *testing.T) {
+	adapter := NewAdapter("md5")
+	db, err := adapter.openDataDB("open_test.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Close()
+	t.Cleanup(func() {
+		_ = os.Remove(adapter.dataDBPath("open_test.db"))
+	})
+
+	info, err := os.Stat(filepath.Dir(adapter.dataDBPath("open_test.db")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := info.Mode().Perm(); perm&0700 != 0700 {
+		t.Errorf("the data directory is created with %s", perm)
+	}
+}
+
+func appendTestEvents(t *testing.T, adapter *Adapter, n int) {
+	t.Helper()
+	for i := 0; i < n; i++ {
+		if err := adapter.AppendEvents("/root", Event{Op: Create, Path: "/root/file"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func journalCursors(t *testing.T, adapter *Adapter) []uint64 {
+	t.Helper()
+	entries, err := adapter.ReadEvents(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cursors []uint64
+	for _, entry := range entries {
+		cursors = append(cursors, entry.Cursor)
+	}
+	return cursors
+}
+
+func TestJournalConsumers(t *testing.T) {
+	adapter := newJournalAdapter(t)
+	appendTestEvents(t, adapter, 2)
+
+	// a new consumer starts from the end of the journal
+	if err := adapter.RegisterConsumer("a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := adapter.RegisterConsumer("b"); err != nil {
+		t.Fatal(err)
+	}
+	if cursor, err := adapter.ConsumerCursor("a"); err != nil || cursor != 2 {
+		t.Fatalf("cursor of a: %d, %v", cursor, err)
+	}
+	appendTestEvents(t, adapter, 3)
+
+	entries, err := adapter.ReadEvents(2, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Cursor != 3 || entries[1].Cursor != 4 || entries[0].Root != "/root" {
+		t.Fatalf("unexpected entries: %v", entries)
+	}
+
+	// the entries are removed after all consumers acknowledged them
+	if err = adapter.Ack("a", 4); err != nil {
+		t.Fatal(err)
+	}
+	if got := journalCursors(t, adapter); len(got) != 3 || got[0] != 3 {
+		t.Fatalf("entries acknowledged by one consumer are removed: %v", got)
+	}
+	if err = adapter.Ack("b", 3); err != nil {
+		t.Fatal(err)
+	}
+	if got := journalCursors(t, adapter); len(got) != 2 || got[0] != 4 {
+		t.Fatalf("unexpected entries after ack: %v", got)
+	}
+
+	// the cursor never goes back
+	if err = adapter.Ack("a", 1); err != nil {
+		t.Fatal(err)
+	}
+	if cursor, _ := adapter.ConsumerCursor("a"); cursor != 4 {
+		t.Errorf("the cursor goes back to %d", cursor)
+	}
+	if err = adapter.Ack("c", 1); err != ErrConsumerNotFound {
+		t.Errorf("ack of an unregistered consumer: %v", err)
+	}
+
+	// the retention does not remove the entries a consumer has not acknowledged
+	adapter.SetJournalRetention(JournalRetention{KeepLast: 1})
+	appendTestEvents(t, adapter, 1)
+	if got := journalCursors(t, adapter); len(got) != 3 {
+		t.Fatalf("unacknowledged entries are removed: %v", got)
+	}
+
+	// the entries of the unregistered consumer are not kept anymore
+	if err = adapter.UnregisterConsumer("a"); err != nil {
+		t.Fatal(err)
+	}
+	if got := journalCursors(t, adapter); len(got) != 3 {
+		t.Fatalf("unexpected entries: %v", got)
+	}
+	if err = adapter.UnregisterConsumer("b"); err != nil {
+		t.Fatal(err)
+	}
+	if got := journalCursors(t, adapter); len(got) != 1 || got[0] != 6 {
+		t.Fatalf("unexpected entries without consumers: %v", got)
+	}
+}
+
+func TestJournalRetention(t *testing.T) {
+	adapter := newJournalAdapter(t)
+
+	// the zero value keeps all
+	appendTestEvents(t, adapter, 5)
+	if got := journalCursors(t, adapter); len(got) != 5 {
+		t.Fatalf("unexpected entries: %v", got)
+	}
+
+	adapter.SetJournalRetention(JournalRetention{KeepLast: 3})
+	appendTestEvents(t, adapter, 1)
+	if got := journalCursors(t, adapter); len(got) != 3 || got[0] != 4 {
+		t.Fatalf("unexpected entries of keep last: %v", got)
+	}
+
+	// age the first two entries
+	db, err := adapter.openJournalDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(journalBucket))
+		for _, cursor := range []uint64{4, 5} {
+			j, err := sonic.Marshal(&JournalEntry{Cursor: cursor, At: time.Now().Add(-2 * time.Hour), Root: "/root"})
+			if err != nil {
+				return err
+			}
+			if err = bucket.Put(itob(cursor), j); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	_ = db.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adapter.SetJournalRetention(JournalRetention{KeepLast: 10, MaxAge: time.Hour})
+	appendTestEvents(t, adapter, 1)
+	if got := journalCursors(t, adapter); len(got) != 2 || got[0] != 6 || got[1] != 7 {
+		t.Fatalf("unexpected entries of max age: %v", got)
+	}
+}
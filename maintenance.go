@@ -0,0 +1,323 @@
+package watcher
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/bytedance/sonic"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/multierr"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DBReport is the report of checking or repairing a db file
+type DBReport struct {
+	Path string `yaml:"path" json:"path"`
+	// Corruptions are the errors of the consistency check of bbolt
+	Corruptions []string `yaml:"corruptions" json:"corruptions"`
+	// BadEntries are the entries which could not be decoded
+	BadEntries []*BadEntry `yaml:"bad_entries" json:"bad_entries"`
+}
+
+// BadEntry is an entry which could not be decoded
+type BadEntry struct {
+	Bucket string `yaml:"bucket" json:"bucket"` // the names of the nested buckets joined with " > "
+	Key    string `yaml:"key" json:"key"`
+	Error  string `yaml:"error" json:"error"`
+
+	names [][]byte
+	key   []byte
+}
+
+// OK returns true if there is no corruption and no bad entry
+func (r *DBReport) OK() bool {
+	return len(r.Corruptions) == 0 && len(r.BadEntries) == 0
+}
+
+// DBStats is the sizes of a db file and its buckets
+type DBStats struct {
+	Path    string         `yaml:"path" json:"path"`
+	Size    int64          `yaml:"size" json:"size"`
+	Buckets []*BucketStats `yaml:"buckets" json:"buckets"`
+}
+
+// BucketStats is the size of a bucket, the nested buckets are counted in it
+type BucketStats struct {
+	Bucket  string `yaml:"bucket" json:"bucket"`
+	Entries int    `yaml:"entries" json:"entries"`
+	Bytes   int64  `yaml:"bytes" json:"bytes"` // the total size of keys and values
+}
+
+const quarantineBucket = "quarantine"
+
+// DBFiles returns the db files of the root paths, and the hashing and journal db files
+func (s *Adapter) DBFiles(rootPaths ...string) []string {
+	var files []string
+	for _, rootPath := range rootPaths {
+		files = append(files, s.getDbPath(rootPath))
+	}
+	return append(files, s.dataDBPath(hashingDbFile), s.dataDBPath(journalDbFile))
+}
+
+// CheckDB verifies the consistency of the db file and decodes all of its entries
+func (s *Adapter) CheckDB(path string) (*DBReport, error) {
+	db, err := s.openExistingDB(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	report := &DBReport{Path: path}
+	err = db.View(func(tx *bolt.Tx) error {
+		for err := range tx.Check() {
+			report.Corruptions = append(report.Corruptions, err.Error())
+		}
+
+		report.BadEntries = s.checkEntries(tx)
+		return nil
+	})
+	return report, err
+}
+
+// RepairDB removes the entries which could not be decoded, and the file lists and snapshots left by an interrupted Save.
+// The removed entries are moved to the "quarantine" bucket if quarantine is true.
+func (s *Adapter) RepairDB(path string, quarantine bool) (*DBReport, error) {
+	db, err := s.openExistingDB(path, false)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	report := &DBReport{Path: path}
+	err = db.Update(func(tx *bolt.Tx) error {
+		report.BadEntries = s.checkEntries(tx)
+
+		for _, entry := range report.BadEntries {
+			bucket := s.nestedBucket(tx, entry.names...)
+			if quarantine {
+				q, err := s.createNestedBucket(tx, []byte(quarantineBucket), []byte(entry.Bucket))
+				if err != nil {
+					return err
+				}
+				if err = q.Put(entry.key, bucket.Get(entry.key)); err != nil {
+					return err
+				}
+			}
+
+			if err := bucket.Delete(entry.key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	// discard the incomplete file lists and snapshots of all root paths
+	var keys [][]byte
+	_ = db.View(func(tx *bolt.Tx) error {
+		if bucket := tx.Bucket([]byte(settingBucket)); bucket != nil {
+			_ = bucket.ForEach(func(k, v []byte) error {
+				keys = append(keys, bytes.Clone(k))
+				return nil
+			})
+		}
+		return nil
+	})
+	for _, key := range keys {
+		if err = s.recover(db, key); err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// CompactDB copies the db file to a new one without the free pages, and replaces the db file with it.
+// It returns the sizes before and after compacting.
+func (s *Adapter) CompactDB(path string) (before int64, after int64, err error) {
+	src, err := s.openExistingDB(path, true)
+	if err != nil {
+		return
+	}
+
+	tmpPath := path + ".compact"
+	_ = os.Remove(tmpPath)
+	dst, err := s.openDB(tmpPath)
+	if err != nil {
+		_ = src.Close()
+		return
+	}
+
+	err = bolt.Compact(dst, src, 64<<20)
+	err = multierr.Combine(err, dst.Close(), src.Close())
+	if err != nil {
+		_ = os.Remove(tmpPath)
+		return
+	}
+
+	before, after = fileSize(path), fileSize(tmpPath)
+	err = os.Rename(tmpPath, path)
+	return
+}
+
+// StatsDB returns the sizes of the db file and its top level buckets,
+// the buckets of the root paths are reported separately
+func (s *Adapter) StatsDB(path string) (*DBStats, error) {
+	db, err := s.openExistingDB(path, true)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	stats := &DBStats{Path: path, Size: fileSize(path)}
+	err = db.View(func(tx *bolt.Tx) error {
+		return tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+			switch string(name) {
+			case fileBucket, snapshotBucket, snapshotDeltaBucket, checkpointBucket:
+				// nested by root paths
+				return b.ForEach(func(k, v []byte) error {
+					if v == nil {
+						stats.Buckets = append(stats.Buckets, bucketStats(b.Bucket(k), string(name), string(k)))
+					}
+					return nil
+				})
+			default:
+				stats.Buckets = append(stats.Buckets, bucketStats(b, string(name)))
+			}
+			return nil
+		})
+	})
+	return stats, err
+}
+
+func bucketStats(bucket *bolt.Bucket, names ...string) *BucketStats {
+	stats := &BucketStats{Bucket: strings.Join(names, " > ")}
+	var count func(b *bolt.Bucket)
+	count = func(b *bolt.Bucket) {
+		_ = b.ForEach(func(k, v []byte) error {
+			stats.Bytes += int64(len(k) + len(v))
+			if v == nil {
+				count(b.Bucket(k))
+			} else {
+				stats.Entries++
+			}
+			return nil
+		})
+	}
+	count(bucket)
+	return stats
+}
+
+// checkEntries decodes all entries of the db, returns the entries could not be decoded
+func (s *Adapter) checkEntries(tx *bolt.Tx) []*BadEntry {
+	var badEntries []*BadEntry
+
+	var walk func(b *bolt.Bucket, names [][]byte)
+	walk = func(b *bolt.Bucket, names [][]byte) {
+		_ = b.ForEach(func(k, v []byte) error {
+			if v == nil {
+				walk(b.Bucket(k), append(names[:len(names):len(names)], bytes.Clone(k)))
+				return nil
+			}
+
+			if err := decodeEntry(names, k, v); err != nil {
+				badEntries = append(badEntries, &BadEntry{
+					Bucket: bucketPathString(names),
+					Key:    keyString(names, k),
+					Error:  err.Error(),
+					names:  names,
+					key:    bytes.Clone(k),
+				})
+			}
+			return nil
+		})
+	}
+
+	_ = tx.ForEach(func(name []byte, b *bolt.Bucket) error {
+		if string(name) != quarantineBucket {
+			walk(b, [][]byte{bytes.Clone(name)})
+		}
+		return nil
+	})
+
+	sort.SliceStable(badEntries, func(i, j int) bool {
+		return badEntries[i].Bucket < badEntries[j].Bucket
+	})
+	return badEntries
+}
+
+// decodeEntry decodes the value by the bucket it belongs to
+func decodeEntry(names [][]byte, k, v []byte) error {
+	var err error
+	switch string(names[0]) {
+	case settingBucket:
+		var setting *adapterSetting
+		err = sonic.Unmarshal(v, &setting)
+	case snapshotBucket:
+		var snapshot *Snapshot
+		err = sonic.Unmarshal(v, &snapshot)
+	case snapshotDeltaBucket:
+		_, err = decodeSnapshotEntry(k, v)
+	case journalBucket:
+		var entry *JournalEntry
+		err = sonic.Unmarshal(v, &entry)
+	case checkpointBucket:
+		if len(names) == 2 {
+			var meta *checkpointMeta
+			err = sonic.Unmarshal(v, &meta)
+		} else {
+			_, err = decodeFileInfo(k, v)
+		}
+	case consumerBucket:
+		if len(v) != 8 {
+			err = errCorruptedValue
+		}
+	default:
+		// the file lists, and the legacy file lists or the hashing db which are named by root paths
+		_, err = decodeFileInfo(k, v)
+	}
+	return err
+}
+
+// bucketPathString returns the readable names of the nested buckets,
+// the generations of file lists and the ids of snapshots are numbers
+func bucketPathString(names [][]byte) string {
+	var s []string
+	for i, name := range names {
+		if i == 2 && (string(names[0]) == fileBucket || string(names[0]) == snapshotDeltaBucket) {
+			s = append(s, fmt.Sprintf("#%d", btoi(name)))
+		} else {
+			s = append(s, string(name))
+		}
+	}
+	return strings.Join(s, " > ")
+}
+
+// keyString returns the readable key, the keys of snapshots and journal are numbers
+func keyString(names [][]byte, k []byte) string {
+	if len(k) == 8 && (string(names[0]) == snapshotBucket || string(names[0]) == journalBucket) {
+		return fmt.Sprintf("#%d", btoi(k))
+	}
+	return string(k)
+}
+
+// openExistingDB opens the db file, it returns an error if the file does not exist rather than creating it
+func (s *Adapter) openExistingDB(path string, readOnly bool) (*bolt.DB, error) {
+	if _, err := os.Stat(path); err != nil {
+		return nil, err
+	}
+	return bolt.Open(path, 0665, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: readOnly})
+}
+
+func fileSize(path string) int64 {
+	stat, err := os.Stat(filepath.Clean(path))
+	if err != nil {
+		return 0
+	}
+	return stat.Size()
+}
@@ -0,0 +1,131 @@
+package watcher
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// putRaw puts the raw value to the bucket of the names in the db file
+func putRaw(t *testing.T, adapter *Adapter, path string, key, value []byte, names ...[]byte) {
+	t.Helper()
+	db, err := adapter.openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Update(func(tx *bolt.Tx) error {
+		bucket, err := adapter.createNestedBucket(tx, names...)
+		if err != nil {
+			return err
+		}
+		return bucket.Put(key, value)
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCheckAndRepairFileList(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "a")
+	writeFile(t, filepath.Join(rootPath, "b.txt"), "b")
+	adapter := NewAdapter("md5")
+	watchOnce(t, adapter, rootPath, testOption())
+
+	dbPath := adapter.getDbPath(rootPath)
+	keyName := adapter.pathKey(rootPath)
+	badKey := adapter.pathKey(filepath.Join(rootPath, "a.txt"))
+	putRaw(t, adapter, dbPath, badKey, []byte{9, 9}, adapter.fileBucketNames(keyName, adapter.setting(rootPath).Generation)...)
+
+	report, err := adapter.CheckDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.OK() || len(report.BadEntries) != 1 || report.BadEntries[0].Key != string(badKey) {
+		t.Fatalf("unexpected report: %+v", report)
+	}
+
+	// the bad entry is counted by the scan and replaced by the walked file
+	events := watchOnce(t, adapter, rootPath, testOption())
+	if len(events) != 1 || events[0].Op != Create {
+		t.Fatalf("unexpected events: %v", events)
+	}
+	if report, err = adapter.CheckDB(dbPath); err != nil || !report.OK() {
+		t.Fatalf("the bad entry is not replaced: %+v, %v", report, err)
+	}
+
+	// repair moves the bad entries to the quarantine
+	putRaw(t, adapter, dbPath, badKey, []byte{9, 9}, adapter.fileBucketNames(keyName, adapter.setting(rootPath).Generation)...)
+	if report, err = adapter.RepairDB(dbPath, true); err != nil || len(report.BadEntries) != 1 {
+		t.Fatalf("unexpected repair: %+v, %v", report, err)
+	}
+	if report, err = adapter.CheckDB(dbPath); err != nil || !report.OK() {
+		t.Fatalf("unexpected report after repair: %+v, %v", report, err)
+	}
+	if names := relNames(rootPath, savedFiles(t, adapter, rootPath)); len(names) != 1 || names[0] != "b.txt" {
+		t.Errorf("unexpected file list after repair: %v", names)
+	}
+
+	before, after, err := adapter.CompactDB(dbPath)
+	if err != nil || before <= 0 || after <= 0 {
+		t.Errorf("compact: %d -> %d, %v", before, after, err)
+	}
+	stats, err := adapter.StatsDB(dbPath)
+	if err != nil || len(stats.Buckets) == 0 {
+		t.Errorf("stats: %+v, %v", stats, err)
+	}
+}
+
+func TestBadSetting(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "a")
+	adapter := NewAdapter("md5")
+	watchOnce(t, adapter, rootPath, testOption())
+
+	dbPath := adapter.getDbPath(rootPath)
+	putRaw(t, adapter, dbPath, adapter.pathKey(rootPath), []byte("{bad"), []byte(settingBucket))
+
+	// a bad setting is not a root path never saved
+	err := NewAdapter("md5").LoadAll(rootPath)
+	if err == nil || !strings.Contains(err.Error(), "repair") {
+		t.Fatalf("loading a bad setting: %v", err)
+	}
+	if _, err = adapter.FileHistory(rootPath, "a.txt"); err == nil {
+		t.Error("the history is read without the setting")
+	}
+
+	report, err := adapter.CheckDB(dbPath)
+	if err != nil || len(report.BadEntries) != 1 || report.BadEntries[0].Bucket != settingBucket {
+		t.Fatalf("unexpected report: %+v, %v", report, err)
+	}
+	if _, err = adapter.RepairDB(dbPath, false); err != nil {
+		t.Fatal(err)
+	}
+	if err = NewAdapter("md5").LoadAll(rootPath); err != nil {
+		t.Fatalf("loading the repaired db: %v", err)
+	}
+}
+
+func TestReadFileInfosBadEntry(t *testing.T) {
+	adapter := NewAdapter("md5")
+	dbPath := filepath.Join(t.TempDir(), "hashing.db")
+	key, info := testFileInfo()
+	putRaw(t, adapter, dbPath, key, encodeFileInfo(key, info), []byte("root"))
+	putRaw(t, adapter, dbPath, []byte("/bad"), []byte{9}, []byte("root"))
+
+	db, err := adapter.openDB(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	infos, err := adapter.readFileInfos(db, []byte("root"), []string{string(key), "/bad", "/missing"})
+	if err == nil || !strings.Contains(err.Error(), "/bad") {
+		t.Errorf("the bad entry is not reported: %v", err)
+	}
+	if infos.Len() != 1 || !infos.Has(string(key)) {
+		t.Errorf("unexpected infos: %v", infos.Keys())
+	}
+}
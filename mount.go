@@ -0,0 +1,138 @@
+package watcher
+
+import (
+	"errors"
+	bolt "go.etcd.io/bbolt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// mountTracker tracks the mount points of a root path, a mount point is a directory on another device than its parent.
+// The saved files in a mount point which is unmounted or missing are kept in the file list rather than reported deleted,
+// so an unmounted share does not produce a mass Remove. They are kept until it's mounted again or its deletion
+// is confirmed by Adapter.ConfirmMountPointDeletion.
+type mountTracker struct {
+	saved     map[string]bool     // the saved mount points by the formatted paths
+	byParent  map[string][]string // the saved mount points by the formatted paths of their parents
+	found     []string            // the mount points of this scan, including the kept ones
+	unmounted map[string]bool     // the kept mount points by the formatted paths, they are not walked into
+}
+
+func newMountTracker(saved []string) *mountTracker {
+	t := &mountTracker{
+		saved:     make(map[string]bool),
+		byParent:  make(map[string][]string),
+		unmounted: make(map[string]bool),
+	}
+	for _, path := range saved {
+		t.saved[formatPath(path)] = true
+		t.byParent[formatPath(filepath.Dir(path))] = append(t.byParent[formatPath(filepath.Dir(path))], path)
+	}
+	return t
+}
+
+// mountPoints returns the sorted mount points found by the scan
+func (t *mountTracker) mountPoints() []string {
+	sort.Strings(t.found)
+	return t.found
+}
+
+// checkMounts finds the mount points in the children of the directory, and keeps the saved files of the saved mount points
+// which are not mounted now. It's called before the children are emitted.
+func (wk *walker) checkMounts(children []*FileInfo, dir *walkDir) {
+	childKeys := make(map[string]bool)
+	for _, child := range children {
+		if !child.IsDir() {
+			continue
+		}
+
+		key := formatPath(child.Path())
+		childKeys[key] = true
+		if wk.isMountPoint(child, dir) {
+			wk.mounts.found = append(wk.mounts.found, child.Path())
+			if !wk.mounts.saved[key] {
+				log.Printf("the mount point \"%s\" appeared", child.Path())
+			}
+		} else if wk.mounts.saved[key] {
+			wk.keepMountPoint(child.Path(), "unmounted")
+		}
+	}
+
+	// a missing mount point is kept, but an ignored one is not
+	for _, path := range wk.mounts.byParent[formatPath(dir.path)] {
+		if !childKeys[formatPath(path)] {
+			if _, err := os.Lstat(path); errors.Is(err, fs.ErrNotExist) {
+				wk.keepMountPoint(path, "missing")
+			}
+		}
+	}
+}
+
+func (wk *walker) keepMountPoint(path string, reason string) {
+	log.Printf("[WARN] the mount point \"%s\" is %s, its saved files are kept until it's mounted again "+
+		"or its deletion is confirmed by the \"confirm\" command", path, reason)
+	wk.mounts.unmounted[formatPath(path)] = true
+	wk.mounts.found = append(wk.mounts.found, path)
+	wk.retain(path)
+}
+
+// isMountPoint returns true if the directory is on another device than its parent, false if the devices are unknown
+func (wk *walker) isMountPoint(info *FileInfo, parent *walkDir) bool {
+	device, ok := deviceID(info)
+	return ok && parent.hasDevice && device != parent.device
+}
+
+// crossesDevice returns true if the directory is on another device than the root path
+func (wk *walker) crossesDevice(info *FileInfo) bool {
+	device, ok := deviceID(info)
+	return ok && wk.root.hasDevice && device != wk.root.device
+}
+
+// ConfirmMountPointDeletion confirms the deletion of a saved mount point of the root path, its saved files are not kept
+// anymore, so the next scan reports them deleted if it's still missing or unmounted.
+// It returns ErrNotMountPoint if the path is not a saved mount point of the root path.
+func (s *Adapter) ConfirmMountPointDeletion(rootPath, mountPoint string) error {
+	defer s.lockRoot(rootPath)()
+
+	db, err := s.openDB(s.getDbPath(rootPath))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var setting *adapterSetting
+	if err = db.Update(func(tx *bolt.Tx) error {
+		if setting, err = s.readSettingTx(tx, s.pathKey(rootPath)); err != nil {
+			return err
+		} else if setting == nil {
+			return ErrNotMountPoint
+		}
+
+		var mountPoints []string
+		for _, path := range setting.MountPoints {
+			if formatPath(path) != formatPath(mountPoint) {
+				mountPoints = append(mountPoints, path)
+			}
+		}
+		if len(mountPoints) == len(setting.MountPoints) {
+			return ErrNotMountPoint
+		}
+		setting.MountPoints = mountPoints
+		return s.putSettingTx(tx, s.pathKey(rootPath), setting)
+	}); err != nil {
+		return err
+	}
+
+	// the loaded setting is replaced, the file lists are not changed
+	s.mu.Lock()
+	if _, ok := s.settings[formatPath(rootPath)]; ok {
+		s.settings[formatPath(rootPath)] = setting
+		s.mountPoints[formatPath(rootPath)] = setting.MountPoints
+	}
+	s.mu.Unlock()
+	log.Printf("Confirmed the deletion of the mount point \"%s\" of \"%s\"", mountPoint, rootPath)
+	return nil
+}
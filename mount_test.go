@@ -0,0 +1,167 @@
+package watcher
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// putMountPoints saves the mount points of the root path as the last scan found them
+func putMountPoints(t *testing.T, adapter *Adapter, rootPath string, mountPoints []string) {
+	t.Helper()
+	db, err := adapter.openDB(adapter.getDbPath(rootPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err = db.Update(func(tx *bolt.Tx) error {
+		setting, err := adapter.readSettingTx(tx, adapter.pathKey(rootPath))
+		if err != nil {
+			return err
+		}
+		setting.MountPoints = mountPoints
+		return adapter.putSettingTx(tx, adapter.pathKey(rootPath), setting)
+	}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// the saved files of a mount point which is not mounted are kept
+func TestUnmountedKept(t *testing.T) {
+	rootPath := t.TempDir()
+	mountPoint := filepath.Join(rootPath, "m")
+	writeFile(t, filepath.Join(rootPath, "a"), "a")
+	writeFile(t, filepath.Join(mountPoint, "x"), "x")
+	writeFile(t, filepath.Join(mountPoint, "d", "y"), "y")
+
+	adapter := NewAdapter("md5")
+	w := NewWatcher(adapter)
+	watch := watchAgain(w)
+	if err := w.Add(rootPath, testOption()); err != nil {
+		t.Fatal(err)
+	}
+	watch(t)
+	want := relNames(rootPath, savedFiles(t, adapter, rootPath))
+
+	// the directory was a mount point in the last scan, it's on the device of its parent now
+	putMountPoints(t, adapter, rootPath, []string{mountPoint})
+	if err := os.Remove(filepath.Join(mountPoint, "x")); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range watch(t) {
+		if event.Op == Remove {
+			t.Errorf("unexpected event of the unmounted directory: %s", event)
+		}
+	}
+	if got := adapter.savedMountPoints(rootPath); !reflect.DeepEqual(got, []string{mountPoint}) {
+		t.Errorf("mount points %v", got)
+	}
+
+	// a missing mount point is kept too, until its deletion is confirmed
+	if err := os.RemoveAll(mountPoint); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		for _, event := range watch(t) {
+			t.Errorf("unexpected event of the missing directory: %s", event)
+		}
+	}
+	if got := relNames(rootPath, savedFiles(t, adapter, rootPath)); !reflect.DeepEqual(got, want) {
+		t.Errorf("saved %v, want %v", got, want)
+	}
+
+	if err := adapter.ConfirmMountPointDeletion(rootPath, filepath.Join(rootPath, "a")); !errors.Is(err, ErrNotMountPoint) {
+		t.Errorf("confirming a file which is not a mount point returns %v", err)
+	}
+	// confirmed by another adapter, such as the "confirm" command
+	if err := NewAdapter("md5").ConfirmMountPointDeletion(rootPath, mountPoint); err != nil {
+		t.Fatal(err)
+	}
+	var removed []string
+	for _, event := range watch(t) {
+		if event.Op != Remove {
+			t.Errorf("unexpected event of the confirmed mount point: %s", event)
+		}
+		removed = append(removed, event.Path)
+	}
+	// "x" is removed while it's unmounted, it's kept until now
+	if want := []string{mountPoint, filepath.Join(mountPoint, "d"), filepath.Join(mountPoint, "d", "y"), filepath.Join(mountPoint, "x")}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	if got := adapter.savedMountPoints(rootPath); len(got) != 0 {
+		t.Errorf("mount points %v after the confirmation", got)
+	}
+	if got := relNames(rootPath, savedFiles(t, adapter, rootPath)); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("saved %v after the confirmation", got)
+	}
+}
+
+func TestMountPoints(t *testing.T) {
+	rootPath := t.TempDir()
+	mountPoint := filepath.Join(rootPath, "m")
+	writeFile(t, filepath.Join(rootPath, "a"), "a")
+	if err := os.Mkdir(mountPoint, 0755); err != nil {
+		t.Fatal(err)
+	}
+	mount := func() {
+		if err := exec.Command("mount", "-t", "tmpfs", "none", mountPoint).Run(); err != nil {
+			t.Skip("tmpfs could not be mounted:", err)
+		}
+	}
+	unmount := func() {
+		if err := exec.Command("umount", mountPoint).Run(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	mount()
+	defer exec.Command("umount", mountPoint).Run()
+	writeFile(t, filepath.Join(mountPoint, "x"), "x")
+	writeFile(t, filepath.Join(mountPoint, "d", "y"), "y")
+
+	adapter := NewAdapter("md5")
+	w := NewWatcher(adapter)
+	watch := watchAgain(w)
+	if err := w.Add(rootPath, testOption()); err != nil {
+		t.Fatal(err)
+	}
+	watch(t)
+	if got := adapter.savedMountPoints(rootPath); !reflect.DeepEqual(got, []string{mountPoint}) {
+		t.Fatalf("mount points %v", got)
+	}
+
+	option := testOption()
+	option.OneFileSystem = true
+	if got := relNames(rootPath, walkOnce(t, rootPath, option)); !reflect.DeepEqual(got, []string{"a", "m"}) {
+		t.Errorf("walked %v on one file system", got)
+	}
+
+	// the unmounted files are not removed
+	unmount()
+	// the mtime of the directory under the mount point differs
+	for _, event := range watch(t) {
+		if event.Op == Remove {
+			t.Errorf("unexpected event of the unmounted file system: %s", event)
+		}
+	}
+	if !savedFiles(t, adapter, rootPath).Has(filepath.Join(mountPoint, "d", "y")) {
+		t.Error("the files of the unmounted file system are not kept")
+	}
+
+	// the changes in the file system mounted again are reported
+	mount()
+	writeFile(t, filepath.Join(mountPoint, "x"), "x")
+	var removed []string
+	for _, event := range watch(t) {
+		if event.Op == Remove {
+			removed = append(removed, event.Path)
+		}
+	}
+	if !reflect.DeepEqual(removed, []string{filepath.Join(mountPoint, "d"), filepath.Join(mountPoint, "d", "y")}) {
+		t.Errorf("removed %v", removed)
+	}
+}
@@ -1,5 +1,7 @@
 package watcher
 
+import "time"
+
 // An Op is a type that is used to describe what type
 // of event has occurred during the watching process.
 type Op uint32
@@ -12,6 +14,11 @@ const (
 	Rename Op = 8
 	Chmod  Op = 16
 	Move   Op = 32
+	// RootSuspect is emitted once when the changes of a root path are refused to save by the deletion guard,
+	// it's not filtered by WatchOption.Op
+	RootSuspect Op = 64
+	// RootRemoved is emitted once when a watched root path disappears, it's not filtered by WatchOption.Op
+	RootRemoved Op = 128
 
 	All Op = Create | Write | Remove | Rename | Chmod | Move
 )
@@ -24,6 +31,9 @@ var Ops = map[Op]string{
 	Chmod:  "CHMOD",
 	Move:   "MOVE",
 	All:    "ALL",
+
+	RootSuspect: "ROOT_SUSPECT",
+	RootRemoved: "ROOT_REMOVED",
 }
 
 // String prints the string version of the Op consts
@@ -34,9 +44,57 @@ func (e Op) String() string {
 	return "???"
 }
 
+// WatchOption is the option of a watched path. The file list of a watched directory is saved in the DBFile in it.
+// A watched file or glob pattern has no db of its own, its file list is saved in the DBFile of its root directory
+// (see RootPath), which is created there, so the root directory must be writable.
+// The files and the globs in the same root directory share the db, a single file list of the files matched by any
+// of their patterns, and the option of the last Add.
 type WatchOption struct {
-	Recursive    bool
+	Recursive bool
+	// IgnoreHidden ignores the hidden files and directories, the subtree of a hidden directory is not walked
 	IgnoreHidden bool
-	Ignore       *GitIgnore
+	// IgnoreHiddenFiles and IgnoreHiddenDirs only ignore the hidden files or the hidden directories,
+	// such as keeping ".env" but skipping ".cache/". IgnoreHidden is both of them.
+	IgnoreHiddenFiles bool
+	IgnoreHiddenDirs  bool
+	// HiddenChecker decides whether a file is hidden, default is DotFileHidden on Unix and AttributeHidden on Windows
+	HiddenChecker HiddenChecker
+	Ignore        *GitIgnore
+	// IgnoreFiles honors the .gitignore and .watchignore files in the watched directories with git's scoping and precedence,
+	// and the .git/info/exclude of the repositories and the global excludes file. They are reloaded when changed.
+	IgnoreFiles bool
+	// ExcludesFile is the global excludes file, default is the core.excludesFile of the git config or $XDG_CONFIG_HOME/git/ignore
+	ExcludesFile string
+	// Include only keeps the files matched by its patterns if set, such as "**/*.go", a file in a matched directory is matched.
+	// The directories are always walked and kept, and the excluded files by Ignore, IgnoreFiles and IgnoreHidden
+	// are never included.
+	Include *GitIgnore
+	// Filter skips the files by their metadata, such as size, mtime, depth and type
+	Filter FileFilter
+	// Symlinks is how the symbolic links are walked, default is keeping the links without tracking their targets
+	Symlinks SymlinkPolicy
+	// OneFileSystem does not walk into the directories on other devices than the root path, like `find -xdev`,
+	// the mount points are kept. It does nothing on Windows.
+	OneFileSystem bool
+	// MaxDeleteCount and MaxDeletePercent are the thresholds of the deletion guard, 0 for no limit.
+	// If more saved files of a root path would be reported deleted, such as an unmounted disk, the changes are not saved
+	// and a RootSuspect event is emitted, until the deletion is confirmed by Adapter.ConfirmDeletion
+	// or a scan is under the thresholds again.
+	MaxDeleteCount   int
+	MaxDeletePercent int
+	// AllowMissing adds a path which does not exist yet, it's polled by every Watch until it appears,
+	// then all its files are reported created
+	AllowMissing bool
 	Op           Op
+	// Parallelism is the count of the directories listed concurrently, default is the count of CPUs
+	Parallelism int
+
+	// FastScan skips listing the directories whose mtime and entry count are not changed since the saved scan,
+	// it only works with Recursive
+	FastScan bool
+	// FastScanStatFiles stats the files in the skipped directories to detect the content changes,
+	// otherwise their saved infos are used
+	FastScanStatFiles bool
+	// FullScanInterval is the interval of the full walk in the fast-scan mode, default is 24 hours
+	FullScanInterval time.Duration
 }
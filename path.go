@@ -5,16 +5,19 @@
 package watcher
 
 import (
-	"path/filepath"
-	"strings"
+	"io/fs"
 )
 
+// defaultHiddenChecker is the hidden checker of the platform
+var defaultHiddenChecker HiddenChecker = DotFileHidden
+
 func formatPath(path string) string {
 	return path
 }
 
-func isHiddenFile(path string) (bool, error) {
-	return strings.HasPrefix(filepath.Base(path), "."), nil
+// fileAttributes returns 0, there are no file attributes on Unix
+func fileAttributes(path string, entry fs.DirEntry) (uint32, error) {
+	return 0, nil
 }
 
 func setHidden(path string) error {
@@ -1,28 +1,40 @@
 package watcher
 
 import (
+	"io/fs"
 	"strings"
 	"syscall"
 )
 
+// defaultHiddenChecker is the hidden checker of the platform
+var defaultHiddenChecker = AttributeHidden(nil)
+
 // pathKey returns a byte slice representation of the path.
 // On Windows, the path is converted to lowercase. This is because the path of Windows is case-insensitive.
 func formatPath(path string) string {
 	return strings.ToLower(path)
 }
 
-func isHiddenFile(path string) (bool, error) {
-	pointer, err := syscall.UTF16PtrFromString(path)
-	if err != nil {
-		return false, err
+// fileAttributes returns the attributes of the file, they are in the info of the entry listed from the file system,
+// or they are read by the path
+func fileAttributes(path string, entry fs.DirEntry) (uint32, error) {
+	if info, err := entry.Info(); err == nil {
+		// the saved info has no attributes unless it's from the file system
+		if fi, ok := info.(*FileInfo); ok {
+			info = fi.FileInfo
+		}
+		if info != nil {
+			if data, ok := info.Sys().(*syscall.Win32FileAttributeData); ok {
+				return data.FileAttributes, nil
+			}
+		}
 	}
 
-	attributes, err := syscall.GetFileAttributes(pointer)
+	pointer, err := syscall.UTF16PtrFromString(path)
 	if err != nil {
-		return false, err
+		return 0, err
 	}
-
-	return attributes&syscall.FILE_ATTRIBUTE_HIDDEN != 0, nil
+	return syscall.GetFileAttributes(pointer)
 }
 
 func setHidden(path string) error {
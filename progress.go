@@ -0,0 +1,50 @@
+package watcher
+
+// ProgressReporter receives the progress of the scans, see Adapter.SetProgressReporter.
+// It's called from the scanning goroutine, and concurrently for the different root paths,
+// so a slow reporter slows down the scans.
+type ProgressReporter interface {
+	// ScanStarted is called when the scan of the root path starts
+	ScanStarted(rootPath string)
+	// FilesWalked is called with the count of the walked files, periodically and when the walk is finished
+	FilesWalked(rootPath string, files int64)
+	// BytesHashed is called with the hashed size of the created and updated files after every file
+	BytesHashed(rootPath string, hashed int64, total int64)
+	// SaveProgress is called with the count of the saved entries of the file list, total is 0 if it's unknown
+	SaveProgress(rootPath string, saved int64, total int64)
+	// ScanFinished is called when the scan of the root path is finished, err is the errors of the root path
+	// or the error of the canceled context
+	ScanFinished(rootPath string, err error)
+}
+
+// NopProgressReporter reports nothing, it's the default ProgressReporter.
+// It could be embedded to implement a part of ProgressReporter.
+type NopProgressReporter struct{}
+
+var _ ProgressReporter = NopProgressReporter{}
+
+func (NopProgressReporter) ScanStarted(string)                {}
+func (NopProgressReporter) FilesWalked(string, int64)         {}
+func (NopProgressReporter) BytesHashed(string, int64, int64)  {}
+func (NopProgressReporter) SaveProgress(string, int64, int64) {}
+func (NopProgressReporter) ScanFinished(string, error)        {}
+
+// walkedReportInterval is the count of the walked files between two FilesWalked
+const walkedReportInterval = 1000
+
+// SetProgressReporter sets the reporter of the scans, nil for NopProgressReporter
+func (s *Adapter) SetProgressReporter(reporter ProgressReporter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.progress = reporter
+}
+
+// progressReporter returns the reporter of the scans
+func (s *Adapter) progressReporter() ProgressReporter {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.progress == nil {
+		return NopProgressReporter{}
+	}
+	return s.progress
+}
@@ -0,0 +1,131 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+// progressRecorder records the calls of the scans and the last reported counts
+type progressRecorder struct {
+	mu                    sync.Mutex
+	calls                 []string
+	walked, hashed, saved int64
+	hashTotal             int64
+}
+
+var _ ProgressReporter = (*progressRecorder)(nil)
+
+func (r *progressRecorder) ScanStarted(string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, "started")
+}
+
+func (r *progressRecorder) FilesWalked(_ string, files int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.walked = files
+}
+
+func (r *progressRecorder) BytesHashed(_ string, hashed int64, total int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hashed, r.hashTotal = hashed, total
+}
+
+func (r *progressRecorder) SaveProgress(_ string, saved int64, _ int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.saved = saved
+}
+
+func (r *progressRecorder) ScanFinished(_ string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.calls = append(r.calls, fmt.Sprint("finished ", err))
+}
+
+// captureStdout returns what is written to stdout by fn
+func captureStdout(t *testing.T, fn func()) []byte {
+	t.Helper()
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	defer func() {
+		os.Stdout = stdout
+	}()
+
+	output := make(chan []byte)
+	go func() {
+		out, _ := io.ReadAll(r)
+		output <- out
+	}()
+	fn()
+	w.Close()
+	return <-output
+}
+
+func TestProgressReporter(t *testing.T) {
+	rootPath := t.TempDir()
+	for i := 0; i < 2500; i++ {
+		writeFile(t, filepath.Join(rootPath, fmt.Sprint("d", i%7), fmt.Sprint(i)), "ab")
+	}
+
+	adapter := NewAdapter("md5")
+	recorder := &progressRecorder{}
+	adapter.SetProgressReporter(recorder)
+	w := NewWatcher(adapter)
+	if err := w.Add(rootPath, testOption()); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if out := captureStdout(t, func() { err = w.Watch() }); len(out) != 0 {
+		t.Errorf("the progress is printed to stdout: %q", out)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2500 files and 7 directories are walked and saved, the contents of the files are hashed
+	if recorder.walked != 2507 || recorder.hashed != 5000 || recorder.hashTotal != 5000 || recorder.saved != 2507 {
+		t.Errorf("walked %d, hashed %d of %d, saved %d", recorder.walked, recorder.hashed, recorder.hashTotal, recorder.saved)
+	}
+	if want := []string{"started", "finished <nil>"}; !reflect.DeepEqual(recorder.calls, want) {
+		t.Errorf("calls %v, want %v", recorder.calls, want)
+	}
+
+	// the canceled scan is finished with the error of the context
+	recorder.calls = nil
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err = w.WatchContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("the canceled watch returns %v", err)
+	}
+	if want := []string{"started", "finished context canceled"}; !reflect.DeepEqual(recorder.calls, want) {
+		t.Errorf("calls %v, want %v", recorder.calls, want)
+	}
+
+	// nothing is reported without the reporter
+	recorder.calls = nil
+	adapter.SetProgressReporter(nil)
+	if _, ok := adapter.progressReporter().(NopProgressReporter); !ok {
+		t.Error("the default reporter is not NopProgressReporter")
+	}
+	writeFile(t, filepath.Join(rootPath, "new"), "n")
+	if err = w.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	if len(recorder.calls) != 0 {
+		t.Errorf("the removed reporter is called: %v", recorder.calls)
+	}
+}
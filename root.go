@@ -0,0 +1,85 @@
+package watcher
+
+import (
+	"log"
+	"os"
+)
+
+// addMissing registers a watched path which does not exist yet, it's added by the Watch which finds it.
+// w.mu must be locked.
+func (w *Watcher) addMissing(path string, options WatchOption) {
+	w.missing[path] = options
+	log.Printf("Add missing path: %s, it's watched when it appears", path)
+}
+
+// addAppeared adds the missing paths which appear, the saved file lists of their root paths are loaded
+func (w *Watcher) addAppeared() {
+	w.mu.Lock()
+	var paths []string
+	for path := range w.missing {
+		paths = append(paths, path)
+	}
+	w.mu.Unlock()
+
+	for _, path := range paths {
+		rootPath, pattern, err := splitWatchPath(path)
+		if err != nil {
+			if !os.IsNotExist(err) {
+				log.Printf("[WARN] the missing path \"%s\" could not be added: %s", path, err)
+			}
+			continue
+		}
+
+		w.mu.Lock()
+		options, ok := w.missing[path]
+		if ok {
+			delete(w.missing, path)
+			w.add(path, rootPath, pattern, options)
+		}
+		w.mu.Unlock()
+		if !ok { // removed meanwhile
+			continue
+		}
+
+		if err = w.adapter.load(rootPath); err != nil {
+			log.Printf("[WARN] loading \"%s\" error: %s", rootPath, err)
+		}
+		log.Printf("the missing path \"%s\" appeared", path)
+	}
+}
+
+// checkRemoved returns true if the root path does not exist, a RootRemoved event is emitted when it disappears.
+// The root path keeps watched, it's scanned as a new one when it appears, unless its db appears with it.
+func (w *Watcher) checkRemoved(rootPath string) bool {
+	w.mu.Lock()
+	removed := w.removed[rootPath]
+	w.mu.Unlock()
+
+	if _, err := os.Stat(rootPath); !os.IsNotExist(err) {
+		if removed {
+			w.mu.Lock()
+			delete(w.removed, rootPath)
+			w.mu.Unlock()
+			if err = w.adapter.load(rootPath); err != nil {
+				log.Printf("[WARN] loading \"%s\" error: %s", rootPath, err)
+			}
+			log.Printf("the removed root path \"%s\" appeared", rootPath)
+		}
+		return false
+	} else if removed {
+		return true
+	}
+
+	w.mu.Lock()
+	w.removed[rootPath] = true
+	w.mu.Unlock()
+	w.adapter.forget(rootPath)
+	log.Printf("[WARN] \"%s\": %s", rootPath, ErrWatchedFileDeleted)
+
+	event := Event{Op: RootRemoved, Path: rootPath}
+	if err := w.adapter.AppendEvents(rootPath, event); err != nil {
+		log.Printf("[ERROR] append the journal error: %s", err)
+	}
+	w.emit(event)
+	return true
+}
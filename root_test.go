@@ -0,0 +1,75 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func TestMissingRoots(t *testing.T) {
+	base := t.TempDir()
+	rootPath := filepath.Join(base, "root")
+	adapter := NewAdapter("md5")
+	w := NewWatcher(adapter)
+	watch := watchAgain(w)
+	names := func(events []Event) []string {
+		var names []string
+		for _, event := range events {
+			rel, _ := filepath.Rel(base, event.Path)
+			names = append(names, event.Op.String()+" "+filepath.ToSlash(rel))
+		}
+		sort.Strings(names)
+		return names
+	}
+
+	option := testOption()
+	if err := w.Add(rootPath, option); err == nil {
+		t.Fatal("the missing path is added without AllowMissing")
+	}
+	option.AllowMissing = true
+	for _, path := range []string{rootPath, filepath.Join(base, "later.txt")} {
+		if err := w.Add(path, option); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(w.RootPaths()) != 0 {
+		t.Fatalf("the missing paths are root paths: %v", w.RootPaths())
+	}
+	// loading a missing root path is not an error
+	if err := adapter.LoadAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	if events := watch(t); len(events) != 0 {
+		t.Fatalf("unexpected events of the missing paths: %v", events)
+	}
+
+	// the path is watched when it appears
+	writeFile(t, filepath.Join(rootPath, "a"), "a")
+	writeFile(t, filepath.Join(rootPath, "d", "b"), "b")
+	if got, want := names(watch(t)), []string{"CREATE root/a", "CREATE root/d", "CREATE root/d/b"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	if got := w.RootPaths(); !reflect.DeepEqual(got, []string{rootPath}) {
+		t.Fatalf("root paths %v", got)
+	}
+
+	// RootRemoved is emitted once, the saved files are not removed one by one
+	if err := os.RemoveAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	if got := append(names(watch(t)), names(watch(t))...); !reflect.DeepEqual(got, []string{"ROOT_REMOVED root"}) {
+		t.Fatalf("events %v of the removed root path", got)
+	}
+
+	// the root path appears again with a new file list, since its db is removed with it
+	writeFile(t, filepath.Join(rootPath, "c"), "c")
+	writeFile(t, filepath.Join(base, "later.txt"), "l")
+	if got, want := names(watch(t)), []string{"CREATE later.txt", "CREATE root/c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("events %v, want %v", got, want)
+	}
+	if got := w.RootPaths(); !reflect.DeepEqual(got, []string{base, rootPath}) {
+		t.Errorf("root paths %v", got)
+	}
+}
@@ -0,0 +1,347 @@
+package watcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"path/filepath"
+)
+
+// readChunkSize is the count of the entries read in one transaction by bucketReader
+const readChunkSize = 1000
+
+// scan is a streaming scan of a root path. The walked files are merged with the saved file list in the order of
+// their keys, so neither of the file lists is read into memory as a whole, but the changes are. They are hashed,
+// emitted and saved from memory, so the memory grows with the count of the changes, which is the count of the files
+// on the initial scan or when most files are changed. The db of the root path is kept open until the scan is closed.
+type scan struct {
+	adapter  *Adapter
+	rootPath string
+	keyName  []byte
+	db       *bolt.DB
+	unlock   func()          // the root path is locked until the scan is closed
+	setting  *adapterSetting // the setting before the scan, nil if the root path is never saved
+	progress ProgressReporter
+
+	saved      *bucketReader
+	lastKey    []byte
+	badEntries int
+	retained   map[string]bool // the directories whose saved files are kept without being walked
+
+	created FileInfos
+	updated FileInfos
+	deleted FileInfos
+	chmod   FileInfos // only the mode is changed, they are not hashed again
+	prev    FileInfos // the saved infos of the updated, chmod and deleted files
+	stats   fileStats
+}
+
+// beginScan locks the root path and opens its db for a streaming scan.
+// A root path which is not loaded, such as added while watching, is loaded from the db first.
+// The setting of a loaded root path is read again from the db, for it could be changed by another process,
+// such as the deletion of the root path or of a mount point confirmed by the "confirm" command.
+func (s *Adapter) beginScan(rootPath string) (*scan, error) {
+	unlock := s.lockRoot(rootPath)
+	db, err := s.openDB(s.getDbPath(rootPath))
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	var generation uint64
+	setting := s.setting(rootPath)
+	if !s.loaded(rootPath) {
+		if err = s.loadDB(db, rootPath); err != nil {
+			db.Close()
+			unlock()
+			return nil, err
+		}
+		if setting = s.setting(rootPath); setting != nil {
+			generation = setting.Generation
+		}
+	} else if setting != nil {
+		stored, err := s.readSetting(db, s.pathKey(rootPath))
+		if err != nil {
+			db.Close()
+			unlock()
+			return nil, fmt.Errorf("reading the setting error, run the \"repair\" command to remove it: %w", err)
+		} else if stored != nil {
+			setting = stored
+			s.mu.Lock()
+			s.settings[formatPath(rootPath)] = setting
+			s.mountPoints[formatPath(rootPath)] = setting.MountPoints
+			s.mu.Unlock()
+		}
+		generation = setting.Generation
+	}
+
+	return &scan{
+		adapter:  s,
+		rootPath: rootPath,
+		keyName:  s.pathKey(rootPath),
+		db:       db,
+		unlock:   unlock,
+		setting:  setting,
+		progress: s.progressReporter(),
+		saved:    s.newBucketReader(db, s.fileBucketNames(s.pathKey(rootPath), generation)...),
+		created:  NewFileInfos(),
+		updated:  NewFileInfos(),
+		deleted:  NewFileInfos(),
+		chmod:    NewFileInfos(),
+		prev:     NewFileInfos(),
+		retained: make(map[string]bool),
+	}, nil
+}
+
+func (sc *scan) close() error {
+	defer sc.unlock()
+	return sc.db.Close()
+}
+
+// add merges a walked file, the files must be added in the order of their keys.
+// The saved files before it are deleted, and the hash-sum of a not changed file is copied from the saved one.
+func (sc *scan) add(info *FileInfo) error {
+	key := sc.adapter.pathKey(info.Path())
+	if sc.lastKey != nil && bytes.Compare(key, sc.lastKey) <= 0 {
+		return fmt.Errorf("the walked files are not in order: \"%s\" after \"%s\"", key, sc.lastKey)
+	}
+	sc.lastKey = key
+	sc.stats.add(info)
+	if count := sc.stats.count(); count%walkedReportInterval == 0 {
+		sc.progress.FilesWalked(sc.rootPath, count)
+	}
+
+	for {
+		k, v := sc.saved.peek()
+		if k == nil || bytes.Compare(k, key) > 0 {
+			sc.created.Put(info.Path(), info)
+			return nil
+		}
+		sc.saved.pop()
+
+		old, err := decodeFileInfo(k, v)
+		if err != nil {
+			// the undecodable entry is treated as not existing, it's replaced if the file exists
+			sc.badEntries++
+			continue
+		}
+
+		if !bytes.Equal(k, key) {
+			sc.remove(k, old)
+			continue
+		}
+
+		switch info.changeOf(old) {
+		case Write:
+			sc.updated.Put(info.Path(), info)
+			sc.prev.Put(info.Path(), old)
+			return nil
+		case Chmod:
+			sc.chmod.Put(info.Path(), info)
+			sc.prev.Put(info.Path(), old)
+		}
+
+		// if the content is not changed, use the old hash sum
+		info.FileHashSum = old.FileHashSum
+		return nil
+	}
+}
+
+// retain keeps the saved files in the directory, they are not deleted though they are not walked.
+// It must be called before the files in the directory are merged.
+func (sc *scan) retain(path string) {
+	sc.retained[formatPath(path)] = true
+}
+
+// remove deletes a saved file which is not walked, unless it's in a retained directory
+func (sc *scan) remove(k []byte, old *FileInfo) {
+	if sc.isRetained(old.Path()) {
+		sc.stats.add(old)
+		return
+	}
+	sc.deleted.Put(string(k), old)
+	sc.prev.Put(string(k), old)
+}
+
+// isRetained returns true if the path or one of its parents under the root path is retained
+func (sc *scan) isRetained(path string) bool {
+	if len(sc.retained) == 0 {
+		return false
+	}
+	for ; len(path) > len(sc.rootPath); path = filepath.Dir(path) {
+		if sc.retained[formatPath(path)] {
+			return true
+		}
+	}
+	return false
+}
+
+// finish deletes the saved files after the last walked file
+func (sc *scan) finish() error {
+	sc.progress.FilesWalked(sc.rootPath, sc.stats.count())
+
+	for k, v := sc.saved.peek(); k != nil; k, v = sc.saved.peek() {
+		sc.saved.pop()
+		if old, err := decodeFileInfo(k, v); err != nil {
+			sc.badEntries++
+		} else {
+			sc.remove(k, old)
+		}
+	}
+
+	if sc.saved.err != nil {
+		return sc.saved.err
+	}
+	if sc.badEntries > 0 {
+		log.Printf("[WARN] %d entries of \"%s\" could not be read, run the \"repair\" command to remove them",
+			sc.badEntries, sc.db.Path())
+	}
+	return nil
+}
+
+// commit saves the changes to the file list and records the snapshot, the moved and renamed files are
+// removed from the deleted and created files by compareMv, the mode changes are counted as updated
+func (sc *scan) commit(ctx context.Context, created, updated, deleted, moved, renamed FileInfos) error {
+	changed := NewFileInfos().Append(updated, sc.chmod)
+	entries := buildSnapshotEntries(sc.prev, created, changed, deleted, moved, renamed)
+	snapshot := &Snapshot{
+		Stats:   sc.stats,
+		Created: len(created),
+		Updated: len(changed),
+		Deleted: len(deleted),
+		Moved:   len(moved),
+		Renamed: len(renamed),
+	}
+	return sc.adapter.saveChanges(ctx, sc.db, sc.rootPath, entries, snapshot)
+}
+
+// savedTree returns the active file list as a savedTree
+func (sc *scan) savedTree() savedTree {
+	var generation uint64
+	if sc.setting != nil {
+		generation = sc.setting.Generation
+	}
+	return &bucketTree{adapter: sc.adapter, db: sc.db, names: sc.adapter.fileBucketNames(sc.keyName, generation)}
+}
+
+// checkpointTree returns the walked files of the unfinished scan as a savedTree
+func (sc *scan) checkpointTree() savedTree {
+	return &bucketTree{adapter: sc.adapter, db: sc.db, names: [][]byte{[]byte(checkpointBucket), sc.keyName, []byte(checkpointFilesBucket)}}
+}
+
+// bucketTree reads the directories from a bucket of file infos, every read is a transaction
+type bucketTree struct {
+	adapter *Adapter
+	db      *bolt.DB
+	names   [][]byte
+}
+
+// dir returns the saved info and children of the directory, the undecodable entries are treated as not existing
+func (t *bucketTree) dir(path string) (info *FileInfo, children []*FileInfo) {
+	_ = t.db.View(func(tx *bolt.Tx) error {
+		bucket := t.adapter.nestedBucket(tx, t.names...)
+		if bucket == nil {
+			return nil
+		}
+
+		key := t.adapter.pathKey(path)
+		if v := bucket.Get(key); v != nil {
+			info, _ = decodeFileInfo(key, v)
+		}
+		if info == nil {
+			return nil
+		}
+
+		// the keys of the children have the prefix, the descendants of a child are skipped by seeking
+		// to the key after "child/", which is "child" with the next byte of the separator
+		prefix := append(key, byte(filepath.Separator))
+		c := bucket.Cursor()
+		for k, v := c.Seek(prefix); k != nil && bytes.HasPrefix(k, prefix); {
+			rest := k[len(prefix):]
+			if i := bytes.IndexByte(rest, byte(filepath.Separator)); i >= 0 {
+				k, v = c.Seek(append(append(bytes.Clone(prefix), rest[:i]...), byte(filepath.Separator)+1))
+				continue
+			}
+
+			if child, err := decodeFileInfo(k, v); err == nil {
+				children = append(children, child)
+			}
+			k, v = c.Next()
+		}
+		return nil
+	})
+	return
+}
+
+// bucketReader reads the entries of a bucket in the order of the keys, the entries are read in chunks
+// and each chunk is a transaction, so no transaction is kept open while walking
+type bucketReader struct {
+	adapter *Adapter
+	db      *bolt.DB
+	names   [][]byte
+
+	keys   [][]byte
+	values [][]byte
+	last   []byte // the last key read
+	eof    bool
+	err    error
+}
+
+func (s *Adapter) newBucketReader(db *bolt.DB, names ...[]byte) *bucketReader {
+	return &bucketReader{adapter: s, db: db, names: names}
+}
+
+// peek returns the current entry, nil if there are no more entries
+func (r *bucketReader) peek() ([]byte, []byte) {
+	if len(r.keys) == 0 && !r.eof {
+		r.read()
+	}
+	if len(r.keys) == 0 {
+		return nil, nil
+	}
+	return r.keys[0], r.values[0]
+}
+
+// pop moves to the next entry
+func (r *bucketReader) pop() {
+	if len(r.keys) > 0 {
+		r.keys, r.values = r.keys[1:], r.values[1:]
+	}
+}
+
+func (r *bucketReader) read() {
+	r.err = r.db.View(func(tx *bolt.Tx) error {
+		bucket := r.adapter.nestedBucket(tx, r.names...)
+		if bucket == nil {
+			r.eof = true
+			return nil
+		}
+
+		c := bucket.Cursor()
+		var k, v []byte
+		if r.last == nil {
+			k, v = c.First()
+		} else if k, v = c.Seek(r.last); bytes.Equal(k, r.last) {
+			k, v = c.Next()
+		}
+
+		for ; k != nil && len(r.keys) < readChunkSize; k, v = c.Next() {
+			// skip the nested buckets
+			if v == nil {
+				continue
+			}
+			r.keys = append(r.keys, bytes.Clone(k))
+			r.values = append(r.values, bytes.Clone(v))
+		}
+		r.eof = k == nil
+		if len(r.keys) > 0 {
+			r.last = r.keys[len(r.keys)-1]
+		}
+		return nil
+	})
+	if r.err != nil {
+		r.eof = true
+	}
+}
@@ -0,0 +1,152 @@
+package watcher
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// randomTree writes the files and directories of the names which sort differently as paths and as keys
+func randomTree(t *testing.T, r *rand.Rand, dir string, depth int) {
+	for _, name := range []string{"a", "a-b", "a.b", "a0", "A", "b", "a b"} {
+		path := filepath.Join(dir, name)
+		if _, err := os.Lstat(path); err == nil {
+			continue
+		}
+		if depth < 3 && r.Intn(3) == 0 {
+			randomTree(t, r, path, depth+1)
+		} else if r.Intn(2) == 0 {
+			writeFile(t, path, name)
+		}
+	}
+}
+
+// the streaming scan agrees with the in-memory Compare, and saves the same file list as a full walk
+func TestStreamingScan(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, fastScan := range []bool{false, true} {
+		rootPath := t.TempDir()
+		randomTree(t, r, rootPath, 0)
+		option := testOption()
+		option.FastScan, option.FastScanStatFiles, option.Parallelism = fastScan, true, 3
+
+		for i := 0; i < 6; i++ {
+			var paths []string
+			_ = filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
+				if path != rootPath && filepath.Base(path) != DBFile {
+					paths = append(paths, path)
+				}
+				return nil
+			})
+			changes := 3
+			if i == 3 {
+				// most files are changed, the file list is rewritten
+				changes = len(paths)
+			}
+			for j := 0; j < changes && i > 0; j++ {
+				path := paths[r.Intn(len(paths))]
+				info, err := os.Stat(path)
+				if err != nil {
+					continue
+				}
+				switch r.Intn(3) {
+				case 0:
+					_ = os.RemoveAll(path)
+				case 1:
+					if !info.IsDir() {
+						writeFile(t, path, fmt.Sprint(r.Int()))
+					}
+				case 2:
+					if info.IsDir() {
+						randomTree(t, r, path, 2)
+					}
+				}
+			}
+			time.Sleep(5 * time.Millisecond)
+
+			full := walkOnce(t, rootPath, testOption())
+			created, updated, deleted, moved, renamed, err := NewAdapter("md5").Compare(rootPath, full)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := map[Op]int{Create: created.Len(), Write: updated.Len(), Remove: deleted.Len(), Move: moved.Len(), Rename: renamed.Len()}
+
+			adapter := NewAdapter("md5")
+			got := make(map[Op]int)
+			for _, event := range watchOnce(t, adapter, rootPath, option) {
+				if event.Op == Chmod {
+					event.Op = Write
+				}
+				got[event.Op]++
+			}
+			for op, n := range want {
+				if got[op] != n {
+					t.Errorf("fast-scan %t, cycle %d: %d %s events, %d by Compare", fastScan, i, got[op], op, n)
+				}
+			}
+
+			saved := savedFiles(t, adapter, rootPath)
+			if c, u, d := diffFileInfos(full, saved); c.Len()+u.Len()+d.Len() != 0 || saved.Len() != full.Len() {
+				t.Fatalf("fast-scan %t, cycle %d: created %v, updated %v, deleted %v", fastScan, i, c.Keys(), u.Keys(), d.Keys())
+			}
+			for path, info := range saved {
+				if !info.IsDir() && info.FileHashSum == nil {
+					t.Errorf("fast-scan %t, cycle %d: %s is saved without its hash-sum", fastScan, i, path)
+				}
+			}
+		}
+	}
+}
+
+// the saved file list is read in chunks while merging
+func TestScanChunks(t *testing.T) {
+	rootPath := t.TempDir()
+	for i := 0; i < 2*readChunkSize+500; i++ {
+		writeFile(t, filepath.Join(rootPath, fmt.Sprintf("%04d", i)), "x")
+	}
+	adapter := NewAdapter("md5")
+	watchOnce(t, adapter, rootPath, testOption())
+
+	var removed []string
+	for i := 0; i < 2*readChunkSize+500; i += 3 {
+		removed = append(removed, filepath.Join(rootPath, fmt.Sprintf("%04d", i)))
+		if err := os.Remove(removed[len(removed)-1]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var got []string
+	for _, event := range watchOnce(t, adapter, rootPath, testOption()) {
+		if event.Op != Remove {
+			t.Fatalf("unexpected event: %s", event)
+		}
+		got = append(got, event.Path)
+	}
+	if !reflect.DeepEqual(got, removed) {
+		t.Errorf("removed %d files, want %d", len(got), len(removed))
+	}
+}
+
+func TestScanOrder(t *testing.T) {
+	rootPath := t.TempDir()
+	sc, err := NewAdapter("md5").beginScan(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.close()
+
+	for _, name := range []string{"a", "b"} {
+		path := filepath.Join(rootPath, name)
+		if err = sc.add(&FileInfo{FileName: name, FilePath: path}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = sc.add(&FileInfo{FileName: "a b", FilePath: filepath.Join(rootPath, "a b")}); err == nil || !strings.Contains(err.Error(), "not in order") {
+		t.Errorf("the file out of order is added: %v", err)
+	}
+}
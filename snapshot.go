@@ -0,0 +1,387 @@
+package watcher
+
+import (
+	"encoding/binary"
+	"github.com/bytedance/sonic"
+	bolt "go.etcd.io/bbolt"
+	"sort"
+	"time"
+)
+
+// Snapshot describes one recorded scan of a root path.
+// Only the changes of every scan are stored, the tree of the latest snapshot is the saved file list,
+// older trees are rebuilt by rolling the changes back.
+type Snapshot struct {
+	ID      uint64    `yaml:"id" json:"id"`
+	At      time.Time `yaml:"at" json:"at"`
+	Stats   fileStats `yaml:"stats" json:"stats"`
+	Initial bool      `yaml:"initial" json:"initial"` // the first scan of the root, there is no history before it
+	Created int       `yaml:"created" json:"created"`
+	Updated int       `yaml:"updated" json:"updated"`
+	Deleted int       `yaml:"deleted" json:"deleted"`
+	Moved   int       `yaml:"moved" json:"moved"`
+	Renamed int       `yaml:"renamed" json:"renamed"`
+}
+
+// SnapshotRetention is the policy of pruning old snapshots, the zero value keeps all snapshots.
+// The latest snapshot is always kept. Every scan records a snapshot even without changes, so the retention
+// is what bounds the history of a polling watcher.
+type SnapshotRetention struct {
+	// KeepLast keeps the last n snapshots
+	KeepLast int `yaml:"keep_last" json:"keep_last"`
+	// MaxAge removes the snapshots older than it
+	MaxAge time.Duration `yaml:"max_age" json:"max_age"`
+}
+
+// snapshotEntry is the change of a path in a snapshot
+// Info is the file info after the scan, nil if the path was deleted or moved away
+// Prev is the file info before the scan, nil if the path did not exist
+type snapshotEntry struct {
+	Op      Op        `json:"op"`
+	OldPath string    `json:"old_path,omitempty"` // the source path of a moved or renamed file
+	Info    *FileInfo `json:"info,omitempty"`
+	Prev    *FileInfo `json:"prev,omitempty"`
+}
+
+const snapshotBucket = "snapshot"
+const snapshotDeltaBucket = "snapshot_delta"
+
+func itob(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b
+}
+
+func btoi(b []byte) uint64 {
+	return binary.BigEndian.Uint64(b)
+}
+
+// SetSnapshotRetention sets the retention policy applied after every Save
+func (s *Adapter) SetSnapshotRetention(retention SnapshotRetention) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.retention = retention
+}
+
+// Snapshots returns all snapshots of the root path, ordered by ID
+func (s *Adapter) Snapshots(rootPath string) ([]*Snapshot, error) {
+	defer s.lockRoot(rootPath)()
+
+	db, err := s.openDB(s.getDbPath(rootPath))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var snapshots []*Snapshot
+	err = db.View(func(tx *bolt.Tx) error {
+		snapshots, err = s.readSnapshots(tx, s.pathKey(rootPath))
+		return err
+	})
+	return snapshots, err
+}
+
+// LoadSnapshot returns the file list of the root path as of the snapshot id
+func (s *Adapter) LoadSnapshot(rootPath string, id uint64) (FileInfos, error) {
+	defer s.lockRoot(rootPath)()
+
+	db, err := s.openDB(s.getDbPath(rootPath))
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	var fileInfos FileInfos
+	err = db.View(func(tx *bolt.Tx) error {
+		fileInfos, err = s.readSnapshotTree(tx, s.pathKey(rootPath), id)
+		return err
+	})
+	return fileInfos, err
+}
+
+// DiffSnapshots compares the file lists of two snapshots of the root path
+func (s *Adapter) DiffSnapshots(rootPath string, from, to uint64) (
+	created,
+	updated,
+	deleted,
+	moved,
+	renamed FileInfos,
+	err error,
+) {
+	defer s.lockRoot(rootPath)()
+
+	db, err := s.openDB(s.getDbPath(rootPath))
+	if err != nil {
+		return
+	}
+	defer db.Close()
+
+	var fromInfos, toInfos FileInfos
+	if err = db.View(func(tx *bolt.Tx) error {
+		if fromInfos, err = s.readSnapshotTree(tx, s.pathKey(rootPath), from); err != nil {
+			return err
+		}
+		toInfos, err = s.readSnapshotTree(tx, s.pathKey(rootPath), to)
+		return err
+	}); err != nil {
+		return
+	}
+
+	created, updated, deleted = diffFileInfos(fromInfos, toInfos)
+	moved, renamed = s.compareMv(deleted, created)
+	return
+}
+
+// diffFileInfos stats the created, updated, deleted files from the old file list to the new one,
+// the files whose mode is changed are updated
+func diffFileInfos(oldFileInfos, newFileInfos FileInfos) (created, updated, deleted FileInfos) {
+	created = make(FileInfos)
+	updated = make(FileInfos)
+	deleted = make(FileInfos)
+
+	for path, newFile := range newFileInfos {
+		oldFile, ok := oldFileInfos[path]
+		if !ok {
+			created.Put(path, newFile)
+		} else if newFile.changeOf(oldFile) != 0 {
+			updated.Put(path, newFile)
+		}
+	}
+
+	for path, oldFile := range oldFileInfos {
+		if _, ok := newFileInfos[path]; !ok {
+			deleted.Put(path, oldFile)
+		}
+	}
+	return
+}
+
+// buildSnapshotEntries converts the changes of a scan to the entries of a snapshot
+func buildSnapshotEntries(oldFileInfos, created, updated, deleted, moved, renamed FileInfos) map[string]*snapshotEntry {
+	entries := make(map[string]*snapshotEntry)
+
+	for path, info := range created {
+		entries[path] = &snapshotEntry{Op: Create, Info: info}
+	}
+	for path, info := range updated {
+		entry := &snapshotEntry{Op: Write, Info: info, Prev: oldFileInfos[path]}
+		if entry.Prev != nil && info.changeOf(entry.Prev) == Chmod {
+			entry.Op = Chmod
+		}
+		entries[path] = entry
+	}
+	for path, info := range deleted {
+		entries[path] = &snapshotEntry{Op: Remove, Prev: info}
+	}
+
+	var putMv = func(op Op, infos FileInfos) {
+		// the key of moved/renamed is the old path
+		for oldPath, info := range infos {
+			entries[oldPath] = &snapshotEntry{Op: op, Prev: oldFileInfos[oldPath]}
+			entries[formatPath(info.Path())] = &snapshotEntry{Op: op, OldPath: oldPath, Info: info}
+		}
+	}
+	putMv(Move, moved)
+	putMv(Rename, renamed)
+
+	return entries
+}
+
+// putSnapshot records a new snapshot with its entries, the ID of the snapshot is assigned here
+func (s *Adapter) putSnapshot(tx *bolt.Tx, keyName []byte, snapshot *Snapshot, entries map[string]*snapshotEntry) error {
+	metaBucket, err := s.createNestedBucket(tx, []byte(snapshotBucket), keyName)
+	if err != nil {
+		return err
+	}
+	deltaBucket, err := s.createNestedBucket(tx, []byte(snapshotDeltaBucket), keyName)
+	if err != nil {
+		return err
+	}
+
+	if snapshot.ID, err = metaBucket.NextSequence(); err != nil {
+		return err
+	}
+
+	j, err := sonic.Marshal(snapshot)
+	if err != nil {
+		return err
+	}
+	if err = metaBucket.Put(itob(snapshot.ID), j); err != nil {
+		return err
+	}
+
+	// the entries of the initial snapshot are the whole file list, it's no need to store them
+	if snapshot.Initial {
+		return nil
+	}
+
+	bucket, err := deltaBucket.CreateBucketIfNotExists(itob(snapshot.ID))
+	if err != nil {
+		return err
+	}
+	for path, entry := range entries {
+		key := s.pathKey(path)
+		if err = bucket.Put(key, encodeSnapshotEntry(key, entry)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pruneSnapshots removes the old snapshots of the retention policy
+func (s *Adapter) pruneSnapshots(tx *bolt.Tx, keyName []byte) error {
+	s.mu.RLock()
+	retention := s.retention
+	s.mu.RUnlock()
+
+	if retention.KeepLast <= 0 && retention.MaxAge <= 0 {
+		return nil
+	}
+
+	snapshots, err := s.readSnapshots(tx, keyName)
+	if err != nil || len(snapshots) <= 1 {
+		return err
+	}
+
+	metaBucket := s.nestedBucket(tx, []byte(snapshotBucket), keyName)
+	deltaBucket := s.nestedBucket(tx, []byte(snapshotDeltaBucket), keyName)
+
+	now := time.Now()
+	// the latest snapshot is always kept
+	for i, snapshot := range snapshots[:len(snapshots)-1] {
+		expired := retention.MaxAge > 0 && now.Sub(snapshot.At) > retention.MaxAge
+		exceeded := retention.KeepLast > 0 && len(snapshots)-i > retention.KeepLast
+		if !expired && !exceeded {
+			break
+		}
+
+		if err = metaBucket.Delete(itob(snapshot.ID)); err != nil {
+			return err
+		}
+		if deltaBucket != nil && deltaBucket.Bucket(itob(snapshot.ID)) != nil {
+			if err = deltaBucket.DeleteBucket(itob(snapshot.ID)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// readSnapshots reads all snapshots of the root path ordered by ID
+func (s *Adapter) readSnapshots(tx *bolt.Tx, keyName []byte) ([]*Snapshot, error) {
+	var snapshots []*Snapshot
+	bucket := s.nestedBucket(tx, []byte(snapshotBucket), keyName)
+	if bucket == nil {
+		return nil, nil
+	}
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		var snapshot *Snapshot
+		if err := sonic.Unmarshal(v, &snapshot); err != nil {
+			return err
+		}
+		snapshots = append(snapshots, snapshot)
+		return nil
+	})
+
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].ID < snapshots[j].ID
+	})
+	return snapshots, err
+}
+
+// readSnapshotEntries reads the entries of a snapshot
+func (s *Adapter) readSnapshotEntries(tx *bolt.Tx, keyName []byte, id uint64) (map[string]*snapshotEntry, error) {
+	entries := make(map[string]*snapshotEntry)
+	bucket := s.nestedBucket(tx, []byte(snapshotDeltaBucket), keyName, itob(id))
+	if bucket == nil {
+		return entries, nil
+	}
+
+	err := bucket.ForEach(func(k, v []byte) error {
+		entry, err := decodeSnapshotEntry(k, v)
+		if err != nil {
+			return err
+		}
+		entries[string(k)] = entry
+		return nil
+	})
+	return entries, err
+}
+
+// readSnapshotTree rebuilds the file list as of the snapshot id
+// by rolling back the entries of the newer snapshots from the saved file list
+func (s *Adapter) readSnapshotTree(tx *bolt.Tx, keyName []byte, id uint64) (FileInfos, error) {
+	snapshots, err := s.readSnapshots(tx, keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	var found bool
+	for _, snapshot := range snapshots {
+		found = found || snapshot.ID == id
+	}
+	if !found {
+		return nil, ErrSnapshotNotFound
+	}
+
+	bucket, err := s.activeFileBucket(tx, keyName)
+	if err != nil {
+		return nil, err
+	}
+
+	fileInfos := NewFileInfos()
+	if bucket != nil {
+		if err = bucket.ForEach(func(k, v []byte) error {
+			info, err := decodeFileInfo(k, v)
+			if err != nil {
+				return err
+			}
+			fileInfos[string(k)] = info
+			return nil
+		}); err != nil {
+			return nil, err
+		}
+	}
+
+	for i := len(snapshots) - 1; i >= 0 && snapshots[i].ID > id; i-- {
+		entries, err := s.readSnapshotEntries(tx, keyName, snapshots[i].ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for path, entry := range entries {
+			if entry.Prev != nil {
+				fileInfos[path] = entry.Prev
+			} else {
+				delete(fileInfos, path)
+			}
+		}
+	}
+
+	return fileInfos, nil
+}
+
+// nestedBucket returns the bucket of the path of names, nil if any of them does not exist
+func (s *Adapter) nestedBucket(tx *bolt.Tx, names ...[]byte) *bolt.Bucket {
+	bucket := tx.Bucket(names[0])
+	for _, name := range names[1:] {
+		if bucket == nil {
+			return nil
+		}
+		bucket = bucket.Bucket(name)
+	}
+	return bucket
+}
+
+// createNestedBucket creates the bucket of the path of names if not exists
+func (s *Adapter) createNestedBucket(tx *bolt.Tx, names ...[]byte) (*bolt.Bucket, error) {
+	bucket, err := tx.CreateBucketIfNotExists(names[0])
+	for _, name := range names[1:] {
+		if err != nil {
+			return nil, err
+		}
+		bucket, err = bucket.CreateBucketIfNotExists(name)
+	}
+	return bucket, err
+}
@@ -0,0 +1,137 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnapshots(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "a")
+	writeFile(t, filepath.Join(rootPath, "d", "b.txt"), "b")
+
+	adapter := NewAdapter("md5")
+	watchOnce(t, adapter, rootPath, testOption())
+
+	writeFile(t, filepath.Join(rootPath, "c.txt"), "c")
+	if err := os.Rename(filepath.Join(rootPath, "d", "b.txt"), filepath.Join(rootPath, "b.txt")); err != nil {
+		t.Fatal(err)
+	}
+	watchOnce(t, adapter, rootPath, testOption())
+
+	if err := os.Remove(filepath.Join(rootPath, "c.txt")); err != nil {
+		t.Fatal(err)
+	}
+	watchOnce(t, adapter, rootPath, testOption())
+
+	snapshots, err := adapter.Snapshots(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 3 || !snapshots[0].Initial || snapshots[1].Created != 1 || snapshots[1].Moved != 1 || snapshots[2].Deleted != 1 {
+		t.Fatalf("unexpected snapshots: %+v %+v %+v", snapshots[0], snapshots[1], snapshots[2])
+	}
+
+	var trees = map[uint64][]string{
+		1: {"a.txt", "d", "d/b.txt"},
+		2: {"a.txt", "b.txt", "c.txt", "d"},
+		3: {"a.txt", "b.txt", "d"},
+	}
+	for id, want := range trees {
+		tree, err := adapter.LoadSnapshot(rootPath, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := relNames(rootPath, tree); !reflect.DeepEqual(got, want) {
+			t.Errorf("snapshot %d: got %v, want %v", id, got, want)
+		}
+	}
+
+	created, _, deleted, moved, _, err := adapter.DiffSnapshots(rootPath, 1, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Len() != 0 || deleted.Len() != 0 || moved.Len() != 1 {
+		t.Errorf("diff 1..3: created %v, deleted %v, moved %v", created.Keys(), deleted.Keys(), moved.Keys())
+	}
+}
+
+func TestSnapshotUnchangedScan(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "a")
+
+	adapter := NewAdapter("md5")
+	adapter.SetSnapshotRetention(SnapshotRetention{KeepLast: 2})
+	watchOnce(t, adapter, rootPath, testOption())
+	writeFile(t, filepath.Join(rootPath, "b.txt"), "b")
+	watchOnce(t, adapter, rootPath, testOption())
+
+	// the polling without changes is recorded too, the history is bounded by the retention
+	for i := 0; i < 5; i++ {
+		if events := watchOnce(t, adapter, rootPath, testOption()); len(events) != 0 {
+			t.Fatalf("unexpected events: %v", events)
+		}
+	}
+
+	snapshots, err := adapter.Snapshots(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].ID != 6 || snapshots[1].ID != 7 {
+		t.Fatalf("unexpected snapshots: %d", len(snapshots))
+	}
+	for _, snapshot := range snapshots {
+		if snapshot.Created+snapshot.Updated+snapshot.Deleted+snapshot.Moved+snapshot.Renamed != 0 || snapshot.Stats.FileCount != 2 {
+			t.Errorf("unexpected snapshot: %+v", snapshot)
+		}
+	}
+
+	// the tree of a scan without changes is the saved file list
+	tree, err := adapter.LoadSnapshot(rootPath, 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relNames(rootPath, tree); !reflect.DeepEqual(got, []string{"a.txt", "b.txt"}) {
+		t.Errorf("the tree of the snapshot 6: %v", got)
+	}
+
+	// the saved setting is updated
+	before := adapter.setting(rootPath).At
+	time.Sleep(10 * time.Millisecond)
+	watchOnce(t, adapter, rootPath, testOption())
+	if !adapter.setting(rootPath).At.After(before) {
+		t.Error("the setting is not saved by the scan without changes")
+	}
+}
+
+func TestSnapshotRetention(t *testing.T) {
+	rootPath := t.TempDir()
+	adapter := NewAdapter("md5")
+	adapter.SetSnapshotRetention(SnapshotRetention{KeepLast: 2})
+
+	for i := 0; i < 4; i++ {
+		writeFile(t, filepath.Join(rootPath, string(rune('a'+i))), "x")
+		watchOnce(t, adapter, rootPath, testOption())
+	}
+
+	snapshots, err := adapter.Snapshots(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[0].ID != 3 || snapshots[1].ID != 4 {
+		t.Fatalf("unexpected snapshots: %d", len(snapshots))
+	}
+	if _, err = adapter.LoadSnapshot(rootPath, 1); err != ErrSnapshotNotFound {
+		t.Errorf("the pruned snapshot is loaded: %v", err)
+	}
+	tree, err := adapter.LoadSnapshot(rootPath, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := relNames(rootPath, tree); !reflect.DeepEqual(got, []string{"a", "b", "c"}) {
+		t.Errorf("snapshot 3: got %v", got)
+	}
+}
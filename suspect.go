@@ -0,0 +1,138 @@
+package watcher
+
+import (
+	"fmt"
+	bolt "go.etcd.io/bbolt"
+	"log"
+	"time"
+)
+
+// rootSuspect is a scan of a root path which is refused to save, for more files would be deleted than the thresholds.
+// The root path stays suspect until the deletion is confirmed or a scan is under the thresholds again.
+type rootSuspect struct {
+	At        time.Time `yaml:"at" json:"at"`
+	Deleted   int64     `yaml:"deleted" json:"deleted"`
+	Total     int64     `yaml:"total" json:"total"`
+	Confirmed bool      `yaml:"confirmed" json:"confirmed"` // the next scan is saved though it's over the thresholds
+}
+
+// exceedsDeletion returns the error if the deleted files are more than the thresholds of the option
+func exceedsDeletion(option WatchOption, deleted int64, total int64) error {
+	if option.MaxDeleteCount > 0 && deleted > int64(option.MaxDeleteCount) {
+		return fmt.Errorf("%d of %d files would be deleted, more than %d: %w",
+			deleted, total, option.MaxDeleteCount, ErrTooManyDeleted)
+	}
+	if option.MaxDeletePercent > 0 && total > 0 && deleted*100 > total*int64(option.MaxDeletePercent) {
+		return fmt.Errorf("%d of %d files would be deleted, more than %d%%: %w",
+			deleted, total, option.MaxDeletePercent, ErrTooManyDeleted)
+	}
+	return nil
+}
+
+// guardDeletion checks the deleted files of the scan against the thresholds. It returns the error if the changes
+// should not be saved, and marks the root path suspect, a RootSuspect event is emitted when it becomes suspect.
+// The suspect is cleared when the changes are saved.
+func (w *Watcher) guardDeletion(sc *scan, option WatchOption, deleted int) *Error {
+	setting := sc.setting
+	if setting == nil { // the initial scan deletes nothing
+		return nil
+	}
+
+	err := exceedsDeletion(option, int64(deleted), setting.Stats.count())
+	if err == nil {
+		if setting.Suspect != nil {
+			log.Printf("the root path \"%s\" is not suspect anymore", sc.rootPath)
+		}
+		return nil
+	} else if setting.Suspect != nil && setting.Suspect.Confirmed {
+		log.Printf("the deletion of \"%s\" is confirmed: %s", sc.rootPath, err)
+		return nil
+	}
+
+	suspect := &rootSuspect{At: time.Now(), Deleted: int64(deleted), Total: setting.Stats.count()}
+	if putErr := w.adapter.putSuspect(sc.db, sc.rootPath, suspect); putErr != nil {
+		return newError(ErrorClassDB, sc.rootPath, sc.db.Path(), "save suspect", putErr)
+	} else if suspect.Confirmed {
+		// confirmed by another process while scanning
+		log.Printf("the deletion of \"%s\" is confirmed: %s", sc.rootPath, err)
+		return nil
+	}
+
+	log.Printf("[WARN] the changes of \"%s\" are not saved until the deletion is confirmed: %s", sc.rootPath, err)
+	if setting.Suspect == nil {
+		event := Event{Op: RootSuspect, Path: sc.rootPath}
+		if journalErr := w.adapter.AppendEvents(sc.rootPath, event); journalErr != nil {
+			log.Printf("[ERROR] append the journal error: %s", journalErr)
+		}
+		w.emit(event)
+	}
+	return newError(ErrorClassWalk, sc.rootPath, sc.rootPath, "deletion guard", err)
+}
+
+// putSuspect marks the root path suspect, the confirmation is kept if the root path is already suspect.
+// The setting is read in the same transaction, so a confirmation by another process is never overwritten.
+// The root path must be locked.
+func (s *Adapter) putSuspect(db *bolt.DB, rootPath string, suspect *rootSuspect) error {
+	var setting *adapterSetting
+	if err := db.Update(func(tx *bolt.Tx) error {
+		var err error
+		if setting, err = s.readSettingTx(tx, s.pathKey(rootPath)); err != nil {
+			return err
+		} else if setting == nil {
+			return fmt.Errorf("the setting of \"%s\" is not saved", rootPath)
+		}
+
+		if setting.Suspect != nil {
+			suspect.Confirmed = setting.Suspect.Confirmed
+		}
+		setting.Suspect = suspect
+		return s.putSettingTx(tx, s.pathKey(rootPath), setting)
+	}); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.settings[formatPath(rootPath)] = setting
+	return nil
+}
+
+// ConfirmDeletion confirms the deletion of a suspect root path, so the next scan is saved though more files are deleted
+// than the thresholds. It returns ErrNotSuspect if the root path is not suspect.
+func (s *Adapter) ConfirmDeletion(rootPath string) error {
+	defer s.lockRoot(rootPath)()
+
+	db, err := s.openDB(s.getDbPath(rootPath))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	var setting *adapterSetting
+	if err = db.Update(func(tx *bolt.Tx) error {
+		if setting, err = s.readSettingTx(tx, s.pathKey(rootPath)); err != nil {
+			return err
+		} else if setting == nil || setting.Suspect == nil {
+			return ErrNotSuspect
+		}
+		setting.Suspect.Confirmed = true
+		return s.putSettingTx(tx, s.pathKey(rootPath), setting)
+	}); err != nil {
+		return err
+	}
+
+	// the loaded setting is replaced, the file lists are not changed
+	s.mu.Lock()
+	if _, ok := s.settings[formatPath(rootPath)]; ok {
+		s.settings[formatPath(rootPath)] = setting
+	}
+	s.mu.Unlock()
+	log.Printf("Confirmed the deletion of \"%s\", %d of %d files", rootPath, setting.Suspect.Deleted, setting.Suspect.Total)
+	return nil
+}
+
+// Suspect returns true if the changes of the root path are refused to save by the deletion guard
+func (s *Adapter) Suspect(rootPath string) bool {
+	setting := s.setting(rootPath)
+	return setting != nil && setting.Suspect != nil
+}
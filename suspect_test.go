@@ -0,0 +1,178 @@
+package watcher
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// suspectRoot watches the root path of 5 files, then removes 3 of them and watches it again over the thresholds
+func suspectRoot(t *testing.T, option WatchOption) (rootPath string, adapter *Adapter, w *Watcher, watch func() ([]Event, error)) {
+	rootPath = t.TempDir()
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		writeFile(t, filepath.Join(rootPath, name), name)
+	}
+
+	var events []Event
+	adapter = NewAdapter("md5")
+	w = NewWatcher(adapter)
+	w.OnEvent(func(event Event) {
+		events = append(events, event)
+	})
+	if err := w.Add(rootPath, option); err != nil {
+		t.Fatal(err)
+	}
+	watch = func() ([]Event, error) {
+		events = nil
+		err := w.Watch()
+		return events, err
+	}
+	if _, err := watch(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"a", "b", "c"} {
+		if err := os.Remove(filepath.Join(rootPath, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := watch(); !errors.Is(err, ErrTooManyDeleted) {
+		t.Fatalf("the deletion is not guarded: %v", err)
+	}
+	return rootPath, adapter, w, watch
+}
+
+// a deletion confirmed by another process is honored by the running watcher, and never overwritten by it
+func TestConfirmDeletionByOther(t *testing.T) {
+	option := testOption()
+	option.MaxDeleteCount = 2
+	rootPath, adapter, _, watch := suspectRoot(t, option)
+
+	// the "confirm" command has its own adapter
+	other := NewAdapter("md5")
+	if err := other.LoadAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	if err := other.ConfirmDeletion(rootPath); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := watch()
+	if err != nil {
+		t.Fatalf("the confirmed deletion is guarded: %v", err)
+	}
+	var removed int
+	for _, event := range events {
+		if event.Op == Remove {
+			removed++
+		}
+	}
+	if removed != 3 || adapter.Suspect(rootPath) {
+		t.Errorf("unexpected events after the confirmation: %v", events)
+	}
+}
+
+// the confirmation is kept when the root path is marked suspect again
+func TestPutSuspectKeepsConfirmation(t *testing.T) {
+	option := testOption()
+	option.MaxDeleteCount = 2
+	rootPath, adapter, _, _ := suspectRoot(t, option)
+
+	if err := NewAdapter("md5").ConfirmDeletion(rootPath); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := adapter.beginScan(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the setting in memory is stale without the scan reading it again
+	adapter.settings[formatPath(rootPath)].Suspect.Confirmed = false
+	suspect := &rootSuspect{Deleted: 3, Total: 5}
+	err = adapter.putSuspect(sc.db, rootPath, suspect)
+	sc.close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !suspect.Confirmed {
+		t.Error("the confirmation in the db is overwritten")
+	}
+
+	stored := NewAdapter("md5")
+	if err = stored.LoadAll(rootPath); err != nil {
+		t.Fatal(err)
+	}
+	if setting := stored.setting(rootPath); setting.Suspect == nil || !setting.Suspect.Confirmed {
+		t.Errorf("unexpected suspect in the db: %+v", setting.Suspect)
+	}
+}
+
+func TestExceedsDeletion(t *testing.T) {
+	tests := []struct {
+		option  WatchOption
+		deleted int64
+		total   int64
+		want    bool
+	}{
+		{WatchOption{}, 100, 100, false},
+		{WatchOption{MaxDeleteCount: 2}, 2, 5, false},
+		{WatchOption{MaxDeleteCount: 2}, 3, 5, true},
+		{WatchOption{MaxDeletePercent: 50}, 5, 10, false},
+		{WatchOption{MaxDeletePercent: 50}, 6, 10, true},
+		{WatchOption{MaxDeletePercent: 50}, 1, 0, false},
+		{WatchOption{MaxDeleteCount: 10, MaxDeletePercent: 50}, 3, 4, true},
+	}
+	for _, tt := range tests {
+		if err := exceedsDeletion(tt.option, tt.deleted, tt.total); errors.Is(err, ErrTooManyDeleted) != tt.want {
+			t.Errorf("%d of %d with %d, %d%%: %v", tt.deleted, tt.total, tt.option.MaxDeleteCount, tt.option.MaxDeletePercent, err)
+		}
+	}
+}
+
+func TestRootSuspect(t *testing.T) {
+	option := testOption()
+	option.MaxDeletePercent = 50
+	rootPath, adapter, _, watch := suspectRoot(t, option)
+
+	// the RootSuspect event is emitted once, and nothing is saved until the deletion is confirmed
+	events, err := watch()
+	if !errors.Is(err, ErrTooManyDeleted) || len(events) != 0 {
+		t.Fatalf("watching the suspect root path again: %v, %v", events, err)
+	}
+	if names := relNames(rootPath, savedFiles(t, adapter, rootPath)); len(names) != 5 {
+		t.Errorf("the changes are saved: %v", names)
+	}
+	entries, err := adapter.ReadEvents(0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var journaled int
+	for _, entry := range entries {
+		if entry.Root == rootPath && entry.Event.Op == RootSuspect {
+			journaled++
+		}
+	}
+	if journaled != 1 {
+		t.Errorf("%d RootSuspect events are journaled", journaled)
+	}
+
+	loaded := NewAdapter("md5")
+	if err = loaded.LoadAll(rootPath); err != nil || !loaded.Suspect(rootPath) {
+		t.Fatalf("the suspect is not saved: %v", err)
+	}
+
+	// a scan under the thresholds clears the suspect
+	writeFile(t, filepath.Join(rootPath, "a"), "a")
+	writeFile(t, filepath.Join(rootPath, "b"), "b")
+	if events, err = watch(); err != nil || adapter.Suspect(rootPath) {
+		t.Fatalf("the suspect is not cleared: %v", err)
+	}
+	// the restored files are rewritten
+	if len(events) != 3 || events[2].Op != Remove || events[2].Path != filepath.Join(rootPath, "c") {
+		t.Errorf("unexpected events: %v", events)
+	}
+	if err = adapter.ConfirmDeletion(rootPath); !errors.Is(err, ErrNotSuspect) {
+		t.Errorf("confirming the root path not suspect: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package watcher
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SymlinkPolicy is how the symbolic links are walked
+type SymlinkPolicy int
+
+// SymlinkPolicies
+const (
+	// SymlinkKeep keeps the links as they are, the targets are not tracked
+	SymlinkKeep SymlinkPolicy = iota
+	// SymlinkIgnore skips the links
+	SymlinkIgnore
+	// SymlinkRecord records the targets of the links, a retargeted link is a Write
+	SymlinkRecord
+	// SymlinkFollow replaces the links with their targets and walks into the linked directories, the targets are recorded.
+	// A link is kept as it is if its target does not exist, is outside the root path, or is a directory being walked (a loop).
+	SymlinkFollow
+)
+
+var SymlinkPolicies = map[SymlinkPolicy]string{
+	SymlinkKeep:   "KEEP",
+	SymlinkIgnore: "IGNORE",
+	SymlinkRecord: "RECORD",
+	SymlinkFollow: "FOLLOW",
+}
+
+// String prints the string version of the SymlinkPolicy consts
+func (p SymlinkPolicy) String() string {
+	if name, found := SymlinkPolicies[p]; found {
+		return name
+	}
+	return "???"
+}
+
+// walkDir is the state of a walked directory inherited by its subdirectories
+type walkDir struct {
+	parent *walkDir
+	path   string
+	// the device of the directory, hasDevice is false if it's unknown
+	device    uint64
+	hasDevice bool
+	// rules is the ignore rules of the directory, nil if WatchOption.IgnoreFiles is not set
+	rules *ignoreRules
+	// real is the path with the links resolved, only if the links are followed
+	real string
+}
+
+// realPath returns the path of the directory with the links resolved, empty if the links are not followed.
+// It's resolved from the parent unless the directory is the root path or a followed link.
+func (wk *walker) realPath(dir string, info os.FileInfo, parent *walkDir) (string, error) {
+	if wk.option.Symlinks != SymlinkFollow {
+		return "", nil
+	}
+
+	if fi, ok := info.(*FileInfo); parent.real == "" || (ok && fi.LinkTarget != "") {
+		return filepath.EvalSymlinks(dir)
+	}
+	return filepath.Join(parent.real, filepath.Base(dir)), nil
+}
+
+// followLink returns the info of the target of the link in the directory, nil if the link is not followed
+func (wk *walker) followLink(path string, dir *walkDir) os.FileInfo {
+	real, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return nil
+	}
+
+	// the root path is the top of the directories
+	root := dir
+	for root.parent != nil && root.parent.real != "" {
+		root = root.parent
+	}
+	if !isWithin(root.real, real) {
+		return nil
+	}
+
+	info, err := os.Stat(real)
+	if err != nil {
+		return nil
+	}
+	if info.IsDir() {
+		for d := dir; d != nil; d = d.parent {
+			if d.real != "" && isWithin(real, d.real) {
+				return nil
+			}
+		}
+	}
+	return info
+}
+
+// isWithin returns true if the path is the directory or in it
+func isWithin(dir string, path string) bool {
+	dir, path = formatPath(dir), formatPath(path)
+	return path == dir || strings.HasPrefix(path, strings.TrimSuffix(dir, string(filepath.Separator))+string(filepath.Separator))
+}
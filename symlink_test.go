@@ -0,0 +1,115 @@
+package watcher
+
+import (
+	"github.com/samber/lo"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// symlinkTree writes a root path with the links to a file, a directory, a parent, the outside and nothing
+func symlinkTree(t *testing.T) string {
+	base := t.TempDir()
+	rootPath := filepath.Join(base, "root")
+	writeFile(t, filepath.Join(rootPath, "a"), "aaa")
+	writeFile(t, filepath.Join(rootPath, "b"), "bbbb")
+	writeFile(t, filepath.Join(rootPath, "d", "x"), "x")
+	writeFile(t, filepath.Join(base, "outside", "o"), "o")
+	for link, target := range map[string]string{
+		"la":   "a",
+		"ld":   "d",
+		"d/up": "..",
+		"lo":   filepath.Join("..", "outside"),
+		"lx":   "nothing",
+	} {
+		if err := os.Symlink(target, filepath.Join(rootPath, link)); err != nil {
+			t.Skip("symlinks are not supported:", err)
+		}
+	}
+	return rootPath
+}
+
+func TestSymlinkPolicies(t *testing.T) {
+	rootPath := symlinkTree(t)
+
+	tests := []struct {
+		policy SymlinkPolicy
+		want   []string
+		// the links whose targets are recorded
+		targets map[string]string
+		// the links replaced by their targets
+		followed []string
+	}{
+		{SymlinkKeep, []string{"a", "b", "d", "d/up", "d/x", "la", "ld", "lo", "lx"}, nil, nil},
+		{SymlinkIgnore, []string{"a", "b", "d", "d/x"}, nil, nil},
+		{SymlinkRecord, []string{"a", "b", "d", "d/up", "d/x", "la", "ld", "lo", "lx"},
+			map[string]string{"la": "a", "lx": "nothing", "d/up": ".."}, nil},
+		// the loops, the links out of the root path and the broken links are kept as links
+		{SymlinkFollow, []string{"a", "b", "d", "d/up", "d/x", "la", "ld", "ld/up", "ld/x", "lo", "lx"},
+			map[string]string{"la": "a", "ld": "d", "d/up": ".."}, []string{"la", "ld"}},
+	}
+	for _, tt := range tests {
+		option := testOption()
+		option.Symlinks = tt.policy
+		infos := walkOnce(t, rootPath, option)
+		if got := relNames(rootPath, infos); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: walked %v, want %v", tt.policy, got, tt.want)
+			continue
+		}
+
+		for link, target := range tt.targets {
+			if info := infos[formatPath(filepath.Join(rootPath, link))]; info.LinkTarget != target {
+				t.Errorf("%s: the target of %s is %q, want %q", tt.policy, link, info.LinkTarget, target)
+			}
+		}
+		for _, link := range []string{"la", "ld", "lo", "lx", "d/up"} {
+			info := infos[formatPath(filepath.Join(rootPath, link))]
+			if info == nil {
+				continue
+			}
+			if followed := info.Mode()&os.ModeSymlink == 0; followed != lo.Contains(tt.followed, link) {
+				t.Errorf("%s: %s is followed: %v", tt.policy, link, followed)
+			}
+		}
+	}
+
+	// a followed link is named as the link, with the info of its target
+	option := testOption()
+	option.Symlinks = SymlinkFollow
+	info := walkOnce(t, rootPath, option)[formatPath(filepath.Join(rootPath, "la"))]
+	if info.Name() != "la" || info.Size() != 3 || !info.Mode().IsRegular() {
+		t.Errorf("the followed link: %+v", info)
+	}
+}
+
+// a retargeted link is a Write, its target is saved
+func TestSymlinkRetarget(t *testing.T) {
+	rootPath := symlinkTree(t)
+	option := testOption()
+	option.Symlinks = SymlinkRecord
+	adapter := NewAdapter("md5")
+	watchOnce(t, adapter, rootPath, option)
+
+	link := filepath.Join(rootPath, "la")
+	if err := os.Remove(link); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("b", link); err != nil {
+		t.Fatal(err)
+	}
+
+	events := watchOnce(t, adapter, rootPath, option)
+	var written bool
+	for _, event := range events {
+		if event.Path == link {
+			written = event.Op == Write
+		}
+	}
+	if !written {
+		t.Errorf("the retargeted link is not written: %v", events)
+	}
+	if saved := savedFiles(t, adapter, rootPath)[formatPath(link)]; saved == nil || saved.LinkTarget != "b" {
+		t.Errorf("the saved link: %+v", saved)
+	}
+}
@@ -0,0 +1,527 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// defaultFullScanInterval is the interval of the full walk in the fast-scan mode if not set
+const defaultFullScanInterval = 24 * time.Hour
+
+// walker lists the files of a root path in the order of their keys, so they are merged with the saved file list
+// while walking. The children of the upcoming subdirectories are read ahead by at most Parallelism-1 goroutines,
+// a subdirectory is read in the current goroutine if it's not read ahead.
+// In the fast-scan mode, the directories whose mtime and entry count are not changed since the saved scan are not listed,
+// their saved children are used instead. An unfinished scan is resumed in the same way from its checkpoint.
+type walker struct {
+	ctx      context.Context // the walk stops on its cancellation
+	rootPath string
+	option   WatchOption
+	// emit receives the walked files in the order of their keys
+	emit func(info *FileInfo) error
+	// retain keeps the saved files in the directory which are not walked, they are not reported deleted
+	retain func(path string)
+
+	// the saved file list or the checkpoint whose children are reused
+	saved     savedTree
+	statFiles bool
+
+	// the compiled ignore files if WatchOption.IgnoreFiles is set, the rules of the root path are from its parents
+	ignoreFiles    *ignoreFileCache
+	rootRules      *ignoreRules
+	globalExcludes *GitIgnore
+
+	// checkpoint saves the walked directories and their files every checkpointInterval, nil for not saving
+	checkpoint   func(dirs int, infos FileInfos) error
+	pending      FileInfos
+	pendingDirs  int
+	checkpointAt time.Time
+	checkpoints  int
+
+	wg  sync.WaitGroup
+	sem chan struct{} // the tokens of the read-ahead goroutines, a token is released when its result is used
+
+	// the root directory and the mount points under it
+	root   *walkDir
+	mounts *mountTracker
+
+	listedDirs atomic.Int64
+	reusedDirs atomic.Int64
+	filtered   atomic.Int64
+}
+
+// savedTree is a saved file list whose children of the unchanged directories are reused by the walker
+type savedTree interface {
+	// dir returns the saved info and children of the directory, the info is nil if the directory is not saved
+	dir(path string) (*FileInfo, []*FileInfo)
+}
+
+// readAhead is the children of a subdirectory read by another goroutine
+type readAhead struct {
+	done     chan struct{}
+	children []*FileInfo
+	dir      *walkDir
+	err      error
+}
+
+// walkItem is a file or the subtree of a directory, a subtree is keyed by the name with a trailing separator,
+// so the items of a directory sorted by the keys are in the order of the keys of their paths
+type walkItem struct {
+	key     string
+	info    *FileInfo
+	subtree bool
+}
+
+// walk lists the files of the root path into the scan, the fast-scan mode is used if it's enabled and a full walk is not due
+func (w *Watcher) walk(ctx context.Context, sc *scan, rootPath string, option WatchOption) error {
+	parallelism := option.Parallelism
+	if parallelism <= 0 {
+		parallelism = runtime.NumCPU()
+	}
+
+	wk := &walker{
+		ctx:      ctx,
+		rootPath: rootPath,
+		option:   option,
+		emit:     sc.add,
+		retain:   sc.retain,
+		mounts:   newMountTracker(w.adapter.savedMountPoints(rootPath)),
+		// the current goroutine is one of them
+		sem: make(chan struct{}, parallelism-1),
+	}
+
+	fullScan := true
+	if w.adapter.ScanIncomplete(rootPath) && option.Recursive {
+		// all entries are stat-ed, so it's as complete as a full walk
+		wk.saved, wk.statFiles = sc.checkpointTree(), true
+		log.Printf("resuming the scan of \"%s\" from its checkpoint", rootPath)
+	} else if option.FastScan && option.Recursive {
+		interval := option.FullScanInterval
+		if interval <= 0 {
+			interval = defaultFullScanInterval
+		}
+
+		if sc.setting != nil && time.Since(w.adapter.lastFullScan(rootPath)) < interval {
+			fullScan = false
+			wk.saved, wk.statFiles = sc.savedTree(), option.FastScanStatFiles
+		}
+	}
+
+	if option.IgnoreFiles {
+		if err := wk.loadRootRules(w.ignoreFiles); err != nil {
+			return err
+		}
+	}
+
+	if option.Recursive {
+		wk.pending = NewFileInfos()
+		wk.checkpointAt = time.Now()
+		wk.checkpoint = func(dirs int, infos FileInfos) error {
+			return w.adapter.putCheckpoint(sc.db, sc.keyName, dirs, infos)
+		}
+	}
+
+	if err := wk.walkRoot(); err != nil {
+		return err
+	}
+	sc.stats.Filtered = wk.filtered.Load()
+	if err := sc.finish(); err != nil {
+		return err
+	}
+
+	w.adapter.setScanned(rootPath, fullScan, wk.mounts.mountPoints())
+	if option.FastScan {
+		log.Printf("fast-scan of \"%s\": full walk: %t, listed directories: %d, skipped directories: %d",
+			rootPath, fullScan, wk.listedDirs.Load(), wk.reusedDirs.Load())
+	}
+
+	return nil
+}
+
+func (wk *walker) walkRoot() error {
+	info, err := os.Lstat(wk.rootPath)
+	if err != nil {
+		return err
+	}
+
+	children, dir, err := wk.readChildren(wk.rootPath, info, &walkDir{rules: wk.rootRules})
+	if err == nil {
+		wk.root = dir
+		if err = wk.walkChildren(children, dir); err == nil {
+			wk.done(nil, children)
+		}
+	}
+	wk.wg.Wait()
+
+	// a short scan is not checkpointed unless it fails
+	if wk.checkpoints > 0 || err != nil {
+		wk.flush()
+	}
+	return err
+}
+
+// subdirectory is the children of a subdirectory and its state
+type subdirectory struct {
+	children []*FileInfo
+	dir      *walkDir
+}
+
+// walkChildren emits the children of a directory and walks into the subdirectories if it's recursive,
+// dir is the state of the directory
+func (wk *walker) walkChildren(children []*FileInfo, dir *walkDir) error {
+	wk.checkMounts(children, dir)
+
+	items := make([]walkItem, 0, len(children))
+	for _, child := range children {
+		name := formatPath(child.Name())
+		items = append(items, walkItem{key: name, info: child})
+		if child.IsDir() && wk.walkInto(child) {
+			items = append(items, walkItem{key: name + string(filepath.Separator), info: child, subtree: true})
+		}
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].key < items[j].key
+	})
+
+	// the children of the subdirectories whose entries are emitted but their subtrees are not yet
+	subdirs := make(map[*FileInfo]subdirectory)
+	aheads := make(map[*FileInfo]*readAhead)
+	var next int
+	for i, item := range items {
+		if err := wk.ctx.Err(); err != nil {
+			return err
+		}
+
+		if item.subtree {
+			subdir := subdirs[item.info]
+			delete(subdirs, item.info)
+			if err := wk.walkChildren(subdir.children, subdir.dir); err != nil {
+				return err
+			}
+			wk.done(item.info, subdir.children)
+			continue
+		}
+
+		// the entry count of a directory is known before it's emitted
+		if item.info.IsDir() && wk.walkInto(item.info) {
+			if next <= i {
+				next = i + 1
+			}
+			next = wk.readAheadFrom(items, next, dir, aheads)
+
+			children, subdir, err := wk.awaitChildren(item.info, dir, aheads)
+			if err != nil {
+				return err
+			}
+			item.info.DirEntries = len(children)
+			subdirs[item.info] = subdirectory{children: children, dir: subdir}
+		}
+
+		// a filtered directory is walked into but not kept
+		if item.info.IsDir() && wk.option.Filter.filtered(item.info) {
+			wk.filtered.Add(1)
+			continue
+		}
+		if err := wk.emit(item.info); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// readAheadFrom starts reading the children of the subdirectories from the index while there are idle goroutines,
+// it returns the index of the next item to read ahead
+func (wk *walker) readAheadFrom(items []walkItem, from int, dir *walkDir, aheads map[*FileInfo]*readAhead) int {
+	for ; from < len(items); from++ {
+		item := items[from]
+		if item.subtree || !item.info.IsDir() || !wk.walkInto(item.info) {
+			continue
+		}
+
+		select {
+		case wk.sem <- struct{}{}:
+		default:
+			return from
+		}
+
+		ahead := &readAhead{done: make(chan struct{})}
+		aheads[item.info] = ahead
+		wk.wg.Add(1)
+		go func(info *FileInfo) {
+			defer wk.wg.Done()
+			defer close(ahead.done)
+			ahead.children, ahead.dir, ahead.err = wk.readChildren(info.Path(), info, dir)
+		}(item.info)
+	}
+	return from
+}
+
+// awaitChildren returns the children of the directory which are read ahead, or reads them now
+func (wk *walker) awaitChildren(info *FileInfo, dir *walkDir, aheads map[*FileInfo]*readAhead) ([]*FileInfo, *walkDir, error) {
+	ahead, ok := aheads[info]
+	if !ok {
+		return wk.readChildren(info.Path(), info, dir)
+	}
+
+	<-ahead.done
+	delete(aheads, info)
+	<-wk.sem
+	return ahead.children, ahead.dir, ahead.err
+}
+
+// readChildren returns the children of the directory which are not skipped and the state of the directory,
+// parent is the state of its parent. The ignored children are not stat-ed.
+func (wk *walker) readChildren(dir string, info os.FileInfo, parent *walkDir) ([]*FileInfo, *walkDir, error) {
+	entries, err := wk.reuseChildren(dir, info, parent.rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	reused := entries != nil
+	if !reused {
+		if entries, err = wk.listChildren(dir); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	d := &walkDir{parent: parent, path: dir}
+	d.device, d.hasDevice = deviceID(info)
+	if d.real, err = wk.realPath(dir, info, parent); err != nil {
+		return nil, nil, err
+	}
+	if d.rules, err = wk.dirRules(dir, entries, parent.rules); err != nil {
+		return nil, nil, err
+	} else if reused && d.rules != nil && d.rules.changed {
+		// the ignore files of the directory are changed, the children ignored before may be not ignored now
+		if entries, err = wk.listChildren(dir); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	children := make([]*FileInfo, 0, len(entries))
+	for _, entry := range entries {
+		isLink := entry.Type()&fs.ModeSymlink != 0
+		if isLink && wk.option.Symlinks == SymlinkIgnore {
+			continue
+		}
+
+		path := filepath.Join(dir, entry.Name())
+		if skip, err := wk.skip(path, entry, d.rules); err != nil {
+			return nil, nil, err
+		} else if skip {
+			continue
+		}
+
+		childInfo, err := entry.Info()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var linkTarget string
+		if isLink && (wk.option.Symlinks == SymlinkRecord || wk.option.Symlinks == SymlinkFollow) {
+			if linkTarget, err = os.Readlink(path); err != nil {
+				return nil, nil, err
+			}
+			if wk.option.Symlinks == SymlinkFollow {
+				if target := wk.followLink(path, d); target != nil {
+					childInfo = target
+				}
+			}
+		}
+
+		// the directories are filtered when they are emitted, their children are still walked
+		if !childInfo.IsDir() && wk.option.Filter.filtered(childInfo) {
+			wk.filtered.Add(1)
+			continue
+		}
+
+		child := convertToFileInfo(path, childInfo)
+		if isLink {
+			// the info of a followed link is its target's, except the name
+			child.FileName, child.LinkTarget = entry.Name(), linkTarget
+		}
+		children = append(children, child)
+	}
+	return children, d, nil
+}
+
+// skip returns true if the path should not be in the file list, rules is the ignore rules of its directory.
+// The patterns of WatchOption.Ignore take precedence over the ignore files.
+// A skipped directory is not walked into, so the subtree of an ignored or hidden directory is skipped.
+func (wk *walker) skip(path string, entry fs.DirEntry, rules *ignoreRules) (bool, error) {
+	isDir := entry.IsDir()
+
+	var matched, ignored bool
+	relPath, _ := filepath.Rel(wk.rootPath, path)
+	if relPath != "." && wk.option.Ignore != nil {
+		matched, ignored = wk.option.Ignore.match(ignorePath(relPath, isDir))
+	}
+	if !matched && rules != nil {
+		_, ignored = rules.match(path, isDir)
+	}
+
+	// the files not included are skipped in the whitelist mode
+	if ignored || (!isDir && wk.option.Include != nil && !wk.option.Include.MatchesPath(relPath)) {
+		return true, nil
+	}
+
+	// Ignore hidden files and directories if the option is set, the checker is called only if it's needed
+	if isDir && (wk.option.IgnoreHidden || wk.option.IgnoreHiddenDirs) ||
+		!isDir && (wk.option.IgnoreHidden || wk.option.IgnoreHiddenFiles) {
+		isHidden := wk.option.HiddenChecker
+		if isHidden == nil {
+			isHidden = defaultHiddenChecker
+		}
+		return isHidden(path, entry)
+	}
+	return false, nil
+}
+
+// walkInto returns true if the children of the directory are walked, it's false if it's not recursive,
+// the directory is at the max depth, an unmounted mount point, or on another filesystem with OneFileSystem
+func (wk *walker) walkInto(dir *FileInfo) bool {
+	if !wk.option.Recursive || wk.mounts.unmounted[formatPath(dir.Path())] {
+		return false
+	} else if wk.option.OneFileSystem && wk.crossesDevice(dir) {
+		return false
+	} else if wk.option.Filter.MaxDepth <= 0 {
+		return true
+	}
+
+	relPath, _ := filepath.Rel(wk.rootPath, dir.Path())
+	return strings.Count(relPath, string(filepath.Separator))+1 < wk.option.Filter.MaxDepth
+}
+
+// done adds the walked directory and its files to the pending checkpoint, the subdirectories are added when they are walked.
+// dir is nil for the root path.
+func (wk *walker) done(dir *FileInfo, children []*FileInfo) {
+	if wk.checkpoint == nil {
+		return
+	}
+
+	for _, child := range children {
+		if !child.IsDir() {
+			wk.pending.Put(child.Path(), child)
+		}
+	}
+	if dir != nil {
+		wk.pending.Put(dir.Path(), dir)
+	}
+	wk.pendingDirs++
+
+	if time.Since(wk.checkpointAt) >= checkpointInterval {
+		wk.flush()
+	}
+}
+
+// flush saves the pending checkpoint, checkpoints are best-effort, a failed one is only logged
+func (wk *walker) flush() {
+	if wk.checkpoint == nil || wk.pending.Len() == 0 {
+		return
+	}
+
+	if err := wk.checkpoint(wk.pendingDirs, wk.pending); err != nil {
+		log.Printf("[WARN] saving the checkpoint of \"%s\" error: %s", wk.rootPath, err)
+	}
+	wk.pending = NewFileInfos()
+	wk.pendingDirs = 0
+	wk.checkpointAt = time.Now()
+	wk.checkpoints++
+}
+
+// listChildren lists the children of the directory sorted by name
+func (wk *walker) listChildren(dir string) ([]fs.DirEntry, error) {
+	if err := wk.ctx.Err(); err != nil {
+		return nil, err
+	}
+	wk.listedDirs.Add(1)
+	return os.ReadDir(dir)
+}
+
+// reuseChildren returns the saved children of the directory if its mtime and entry count are not changed,
+// the subdirectories are always stat-ed to decide whether to walk into them,
+// and the files are stat-ed if statFiles is set, otherwise their saved infos are used.
+// It returns nil if the children could not be reused.
+func (wk *walker) reuseChildren(dir string, info os.FileInfo, parent *ignoreRules) ([]fs.DirEntry, error) {
+	// the ignore files are changed, the directories ignored before may be not ignored now
+	if wk.saved == nil || (parent != nil && parent.changed) {
+		return nil, nil
+	}
+
+	saved, savedChildren := wk.saved.dir(dir)
+	if saved == nil || !saved.IsDir() || !saved.ModTime().Equal(info.ModTime()) || saved.DirEntries != len(savedChildren) {
+		return nil, nil
+	}
+
+	children := make([]fs.DirEntry, 0, len(savedChildren))
+	for _, child := range savedChildren {
+		if !child.IsDir() && !wk.statFiles {
+			children = append(children, fs.FileInfoToDirEntry(child))
+			continue
+		}
+
+		current, err := os.Lstat(child.Path())
+		if errors.Is(err, os.ErrNotExist) {
+			// changed after the directory was checked, list it instead
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		children = append(children, fs.FileInfoToDirEntry(current))
+	}
+
+	wk.reusedDirs.Add(1)
+	return children, nil
+}
+
+// loadRootRules loads the global excludes file and the ignore rules of the root path
+func (wk *walker) loadRootRules(ignoreFiles *ignoreFileCache) error {
+	excludesFile := wk.option.ExcludesFile
+	if excludesFile == "" {
+		excludesFile = globalExcludesFile()
+	}
+
+	var globalExcludes *GitIgnore
+	var changed bool
+	if excludesFile != "" {
+		var err error
+		if globalExcludes, changed, err = ignoreFiles.load(excludesFile); err != nil {
+			return err
+		}
+	}
+
+	rules, err := ignoreFiles.rootRules(wk.rootPath, globalExcludes)
+	if err != nil {
+		return err
+	}
+	rules.changed = rules.changed || changed
+
+	wk.ignoreFiles, wk.rootRules, wk.globalExcludes = ignoreFiles, rules, globalExcludes
+	return nil
+}
+
+// dirRules returns the ignore rules of the directory with the ignore files in its entries, nil if IgnoreFiles is not set
+func (wk *walker) dirRules(dir string, entries []fs.DirEntry, parent *ignoreRules) (*ignoreRules, error) {
+	if wk.ignoreFiles == nil {
+		return nil, nil
+	}
+
+	names := make(map[string]bool)
+	for _, entry := range entries {
+		switch name := formatPath(entry.Name()); name {
+		case formatPath(".git"), formatPath(GitIgnoreFile), formatPath(WatchIgnoreFile):
+			names[name] = true
+		}
+	}
+	return wk.ignoreFiles.childRules(parent, dir, names, wk.globalExcludes)
+}
@@ -0,0 +1,254 @@
+package watcher
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFastScan(t *testing.T) {
+	tests := []struct {
+		name     string
+		stat     bool
+		interval time.Duration
+		// a file rewritten in place does not change the mtime of its directory
+		updated []string
+	}{
+		{"saved infos", false, 0, nil},
+		{"stat files", true, 0, []string{"a/y.txt"}},
+		{"full walk due", false, time.Nanosecond, []string{"a/y.txt"}},
+	}
+
+	for _, tt := range tests {
+		rootPath := t.TempDir()
+		writeFile(t, filepath.Join(rootPath, "a", "b", "c", "x.txt"), "1")
+		writeFile(t, filepath.Join(rootPath, "a", "y.txt"), "1")
+		writeFile(t, filepath.Join(rootPath, "d", "z.txt"), "1")
+
+		option := testOption()
+		option.FastScan, option.FastScanStatFiles, option.FullScanInterval = true, tt.stat, tt.interval
+		adapter := NewAdapter("md5")
+		watchOnce(t, adapter, rootPath, option)
+
+		time.Sleep(20 * time.Millisecond)
+		writeFile(t, filepath.Join(rootPath, "a", "b", "c", "new.txt"), "2")
+		writeFile(t, filepath.Join(rootPath, "a", "y.txt"), "changed")
+		if err := os.Remove(filepath.Join(rootPath, "d", "z.txt")); err != nil {
+			t.Fatal(err)
+		}
+
+		events := watchOnce(t, NewAdapter("md5"), rootPath, option)
+		ops := make(map[Op][]string)
+		for _, event := range events {
+			if event.FileInfo != nil && event.IsDir() {
+				// the mtime of the directories of the created and deleted files is changed
+				continue
+			}
+			rel, _ := filepath.Rel(rootPath, event.Path)
+			ops[event.Op] = append(ops[event.Op], filepath.ToSlash(rel))
+		}
+		// the changed directories are listed, so the created and deleted files are always found
+		if !reflect.DeepEqual(ops[Create], []string{"a/b/c/new.txt"}) || !reflect.DeepEqual(ops[Remove], []string{"d/z.txt"}) {
+			t.Errorf("%s: unexpected events: %v", tt.name, events)
+		}
+		if !reflect.DeepEqual(ops[Write], tt.updated) {
+			t.Errorf("%s: updated %v, want %v", tt.name, ops[Write], tt.updated)
+		}
+
+		full := walkOnce(t, rootPath, testOption())
+		created, _, deleted := diffFileInfos(full, savedFiles(t, adapter, rootPath))
+		if created.Len() != 0 || deleted.Len() != 0 {
+			t.Errorf("%s: the saved file list differs from a full walk: created %v, deleted %v", tt.name, created.Keys(), deleted.Keys())
+		}
+	}
+}
+
+// referenceWalk lists the files of the root path with filepath.WalkDir, the ignored directories are skipped
+func referenceWalk(t *testing.T, rootPath string, ignore *GitIgnore) FileInfos {
+	t.Helper()
+	infos := NewFileInfos()
+	if err := filepath.WalkDir(rootPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || path == rootPath {
+			return err
+		}
+		relPath, _ := filepath.Rel(rootPath, path)
+		if ignore.MatchesPath(ignorePath(relPath, entry.IsDir())) {
+			if entry.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		infos.Put(path, convertToFileInfo(path, info))
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return infos
+}
+
+// fixtureTree writes the directories of the count with nested and ignored files
+func fixtureTree(t *testing.T, rootPath string, dirs int) {
+	for i := 0; i < dirs; i++ {
+		for j := 0; j < 5; j++ {
+			writeFile(t, filepath.Join(rootPath, fmt.Sprintf("d%d", i), fmt.Sprintf("e%d", j), "f.txt"), "x")
+			writeFile(t, filepath.Join(rootPath, fmt.Sprintf("d%d", i), fmt.Sprintf("e%d", j), "skip", "g.txt"), "x")
+		}
+		writeFile(t, filepath.Join(rootPath, fmt.Sprintf("d%d", i), "h.log"), "x")
+		writeFile(t, filepath.Join(rootPath, fmt.Sprintf("d%d.txt", i)), "x")
+	}
+}
+
+func TestWalkParallelism(t *testing.T) {
+	rootPath := t.TempDir()
+	fixtureTree(t, rootPath, 30)
+	option := testOption()
+	option.Ignore = CompileIgnoreLines("skip/", "*.log", DBFile)
+	want := referenceWalk(t, rootPath, option.Ignore)
+
+	for _, parallelism := range []int{1, 2, 8, 64} {
+		option.Parallelism = parallelism
+		// the scan fails if the files are not walked in the order of their keys
+		got := walkOnce(t, rootPath, option)
+		if !reflect.DeepEqual(relNames(rootPath, got), relNames(rootPath, want)) {
+			t.Fatalf("parallelism %d: walked %d files, want %d", parallelism, got.Len(), want.Len())
+		}
+		for path, info := range want {
+			if g := got[path]; g.FileName != info.FileName || g.changeOf(info) != 0 {
+				t.Errorf("parallelism %d: %s is walked as %+v", parallelism, path, g)
+			}
+			// the entry count of a directory is the count of its children which are not ignored
+			if wantEntries := map[byte]int{'d': 5, 'e': 1}[info.Name()[0]]; info.IsDir() && got[path].DirEntries != wantEntries {
+				t.Errorf("parallelism %d: %s has %d entries, want %d", parallelism, path, got[path].DirEntries, wantEntries)
+			}
+		}
+	}
+}
+
+// walkedRecorder records the counts reported by FilesWalked
+type walkedRecorder struct {
+	NopProgressReporter
+	mu     sync.Mutex
+	walked []int64
+}
+
+func (r *walkedRecorder) FilesWalked(_ string, files int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.walked = append(r.walked, files)
+}
+
+func TestWalkProgress(t *testing.T) {
+	rootPath := t.TempDir()
+	for i := 0; i < 2*walkedReportInterval+500; i++ {
+		writeFile(t, filepath.Join(rootPath, fmt.Sprint(i%7), fmt.Sprint(i)), "x")
+	}
+	adapter := NewAdapter("md5")
+	recorder := &walkedRecorder{}
+	adapter.SetProgressReporter(recorder)
+	watchOnce(t, adapter, rootPath, testOption())
+
+	// 7 directories and 2500 files
+	want := []int64{walkedReportInterval, 2 * walkedReportInterval, 2*walkedReportInterval + 507}
+	if !reflect.DeepEqual(recorder.walked, want) {
+		t.Errorf("walked %v, want %v", recorder.walked, want)
+	}
+}
+
+// the checkpoints saved while walking add up to the walked files
+func TestWalkCheckpoints(t *testing.T) {
+	rootPath := t.TempDir()
+	fixtureTree(t, rootPath, 10)
+	option := testOption()
+	option.Parallelism = 4
+	want := walkOnce(t, rootPath, option)
+
+	adapter := NewAdapter("md5")
+	sc, err := adapter.beginScan(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.close()
+
+	checkpointed := NewFileInfos()
+	var emitted, dirs int
+	var checkpoints []int
+	wk := &walker{
+		ctx:      context.Background(),
+		rootPath: rootPath,
+		option:   option,
+		emit: func(info *FileInfo) error {
+			emitted++
+			return sc.add(info)
+		},
+		retain:  sc.retain,
+		mounts:  newMountTracker(nil),
+		sem:     make(chan struct{}, option.Parallelism-1),
+		pending: NewFileInfos(),
+		// the checkpoint interval has passed since the zero checkpointAt, the first walked directory is checkpointed
+		checkpoint: func(n int, infos FileInfos) error {
+			for path, info := range infos {
+				if checkpointed.Has(path) {
+					t.Errorf("%s is checkpointed twice", path)
+				}
+				checkpointed[path] = info
+			}
+			checkpoints = append(checkpoints, emitted)
+			dirs += n
+			return nil
+		},
+	}
+	if err = wk.walkRoot(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first checkpoint is saved in the middle of the walk, the rest when the walk is finished
+	if len(checkpoints) != 2 || checkpoints[0] >= want.Len() || checkpoints[1] != want.Len() {
+		t.Errorf("checkpoints after %v of %d files", checkpoints, want.Len())
+	}
+	// the root path, 10 directories, 5 subdirectories in each and a directory in each subdirectory
+	if dirs != 111 {
+		t.Errorf("%d directories are checkpointed", dirs)
+	}
+	if !reflect.DeepEqual(relNames(rootPath, checkpointed), relNames(rootPath, want)) {
+		t.Errorf("checkpointed %d files, want %d", checkpointed.Len(), want.Len())
+	}
+}
+
+func TestInclude(t *testing.T) {
+	rootPath := t.TempDir()
+	for _, path := range []string{"a.go", "a.txt", "src/b.go", "src/c.yaml", "src/d.md", "src/x/e.go", "vendor/f.go", "docs/g.md", "docs/h/i.txt"} {
+		writeFile(t, filepath.Join(rootPath, path), path)
+	}
+
+	tests := []struct {
+		include []string
+		want    []string
+	}{
+		// the directories are always walked and kept
+		{[]string{"*.go"}, []string{"a.go", "docs", "docs/h", "src", "src/b.go", "src/x", "src/x/e.go"}},
+		{[]string{"/*.go"}, []string{"a.go", "docs", "docs/h", "src", "src/x"}},
+		{[]string{"src/**/*.go", "*.yaml"}, []string{"docs", "docs/h", "src", "src/b.go", "src/c.yaml", "src/x", "src/x/e.go"}},
+		// a file in a matched directory is matched
+		{[]string{"docs/"}, []string{"docs", "docs/g.md", "docs/h", "docs/h/i.txt", "src", "src/x"}},
+		{[]string{"*.*", "!*.md"}, []string{"a.go", "a.txt", "docs", "docs/h", "docs/h/i.txt", "src", "src/b.go", "src/c.yaml", "src/x", "src/x/e.go"}},
+	}
+	for _, tt := range tests {
+		option := testOption()
+		// the ignored directories are not walked even if their files are included
+		option.Ignore = CompileIgnoreLines(DBFile, "vendor/")
+		option.Include = CompileIgnoreLines(tt.include...)
+		if got := relNames(rootPath, walkOnce(t, rootPath, option)); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: walked %v, want %v", tt.include, got, tt.want)
+		}
+	}
+}
@@ -1,28 +1,55 @@
 package watcher
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"go.uber.org/multierr"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 )
 
+// Watcher is safe for concurrent use, the paths could be added or removed while watching,
+// they take effect from the next root path. The calls of Watch are serialized.
 type Watcher struct {
 	adapter *Adapter
 
+	// mu guards the watched paths, the handlers and the polling state, watchMu serializes Watch
+	mu      sync.Mutex
+	watchMu sync.Mutex
+	running bool
+	cancel  context.CancelFunc // called by Close to stop the polling
+	stopped chan struct{}      // closed when the polling is stopped
+
 	optionGroup map[string]WatchOption
-	fileGroup   map[string]FileInfos
+	globs       map[string]*globRoot   // the files and the glob patterns by their root directories
+	missing     map[string]WatchOption // the watched paths which do not exist yet
+	removed     map[string]bool        // the root paths which have disappeared
+	handlers    []EventHandler
+	ignoreFiles *ignoreFileCache
 }
 
 func NewWatcher(db *Adapter) *Watcher {
 	return &Watcher{
 		adapter:     db,
 		optionGroup: make(map[string]WatchOption),
-		fileGroup:   make(map[string]FileInfos),
+		globs:       make(map[string]*globRoot),
+		missing:     make(map[string]WatchOption),
+		removed:     make(map[string]bool),
+		ignoreFiles: newIgnoreFileCache(),
 	}
 }
 
-// Add the path to the watch list
+// Add the path to the watch list, the path is a directory, a file or a glob pattern such as "/var/log/app/*.log".
+// A file or a glob is watched in its root directory, see RootPath, only the matched files are tracked and the
+// directories are not. The files and the globs in the same root directory share the DBFile in it and the option
+// of the last Add, and their patterns replace the Include of the option. Adding the root directory itself replaces them.
+// A missing path is an error unless WatchOption.AllowMissing is set.
 func (w *Watcher) Add(path string, options WatchOption) error {
 	var err error
 	path, err = filepath.Abs(path)
@@ -30,22 +57,66 @@ func (w *Watcher) Add(path string, options WatchOption) error {
 		return err
 	}
 
-	_, err = os.Stat(path)
+	rootPath, pattern, err := splitWatchPath(path)
 	if err != nil {
 		if os.IsNotExist(err) {
+			if options.AllowMissing {
+				w.mu.Lock()
+				defer w.mu.Unlock()
+				w.addMissing(path, options)
+				return nil
+			}
 			return errors.New("path does not exist: " + path)
 		}
 		return err
 	}
 
-	w.optionGroup[path] = options
-	w.fileGroup[path] = nil
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	delete(w.missing, path)
+	w.add(path, rootPath, pattern, options)
 
 	log.Printf("Add path: %s", path)
 
 	return nil
 }
 
+// add adds the path to the root path, the pattern is empty if the path is the root path. w.mu must be locked.
+func (w *Watcher) add(path, rootPath, pattern string, options WatchOption) {
+	if pattern == "" {
+		delete(w.globs, rootPath)
+		w.optionGroup[rootPath] = options
+	} else {
+		g := w.globs[rootPath]
+		if g == nil {
+			g = &globRoot{}
+			w.globs[rootPath] = g
+		}
+		g.add(path, pattern, options)
+		w.optionGroup[rootPath] = g.watchOption()
+	}
+}
+
+// RootPaths returns the sorted root directories of the watched paths
+func (w *Watcher) RootPaths() []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var rootPaths []string
+	for rootPath := range w.optionGroup {
+		rootPaths = append(rootPaths, rootPath)
+	}
+	sort.Strings(rootPaths)
+	return rootPaths
+}
+
+// OnEvent adds a handler which is called with every emitted event
+func (w *Watcher) OnEvent(handler EventHandler) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.handlers = append(w.handlers, handler)
+}
+
 // Remove the path from the watch list
 func (w *Watcher) Remove(path string) {
 	absPath, err := filepath.Abs(path)
@@ -53,105 +124,299 @@ func (w *Watcher) Remove(path string) {
 		absPath = path
 	}
 
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	delete(w.missing, absPath)
+	for rootPath, g := range w.globs {
+		if g.remove(absPath) {
+			if len(g.paths) == 0 {
+				delete(w.globs, rootPath)
+				delete(w.optionGroup, rootPath)
+			} else {
+				w.optionGroup[rootPath] = g.watchOption()
+			}
+			return
+		}
+	}
+
+	delete(w.globs, absPath)
+	delete(w.removed, absPath)
 	delete(w.optionGroup, absPath)
-	delete(w.fileGroup, absPath)
 }
 
+// Watch scans the root paths, compares them with the saved file lists, emits the events and saves them.
+// The errors are handled by the policies of their classes, a root path is skipped if it could not be listed.
+// A long scan is checkpointed, so an interrupted or failed one is resumed by the next Watch,
+// the root path is incomplete until its file list is saved, no deletion is reported from a partial file list.
+// A root path which disappears is reported as a RootRemoved event rather than the removal of all its files,
+// and the missing paths are polled by every Watch until they appear.
+// It returns all errors occurred as *Error combined by multierr.
 func (w *Watcher) Watch() error {
-	for path, option := range w.optionGroup {
-		log.Printf("mapping files of \"%s\"...", path)
-		infos, err := w.listFileInfos(path, option)
-		if err != nil {
-			return err
+	return w.WatchContext(context.Background())
+}
+
+// WatchContext is Watch which stops walking, hashing and saving on the cancellation of ctx and returns ctx.Err().
+// The interrupted root path is not saved, its walk is resumed from the checkpoint by the next Watch if it's checkpointed.
+func (w *Watcher) WatchContext(ctx context.Context) (errs error) {
+	w.watchMu.Lock()
+	defer w.watchMu.Unlock()
+
+	defer func() {
+		if errs != nil {
+			log.Printf("[ERROR] watching finished with %s", summarizeErrors(errs))
+		}
+	}()
+
+	w.addAppeared()
+	for _, rootPath := range w.RootPaths() {
+		// the root path could be removed or its option could be changed while watching
+		option, ok := w.watchOption(rootPath)
+		if !ok || w.checkRemoved(rootPath) {
+			continue
 		}
-		w.fileGroup[path] = infos
+		if w.scanRoot(ctx, rootPath, option, &errs) {
+			break
+		}
+	}
 
-		stats := infos.stats()
-		log.Printf("mapping files of \"%s\" done, "+
-			"total size: %s, "+
-			"files: %d, "+
-			"directories: %d, "+
-			"symlinks: %d",
-			path,
-			ByteCountIEC(stats.TotalSize),
-			stats.FileCount,
-			stats.DirCount,
-			stats.LinkCount)
+	if err := ctx.Err(); err != nil {
+		return err
 	}
+	return
+}
 
-	var created, updated, deleted, moved, renamed FileInfos
-	for rootPath, infos := range w.fileGroup {
-		log.Printf("comparing: %s", rootPath)
-		created, updated, deleted, moved, renamed = w.adapter.Compare(rootPath, infos)
-		log.Printf("created: %d, updated: %d, deleted: %d, moved: %d, renamed: %d of \"%s\"", len(created), len(updated), len(deleted), len(moved), len(renamed), rootPath)
+// watchOption returns the option of the root path, false if it's not watched
+func (w *Watcher) watchOption(rootPath string) (WatchOption, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	option, ok := w.optionGroup[rootPath]
+	return option, ok
+}
+
+// Start calls Watch every duration until Close is called, the paths could be added or removed while it's running.
+// The errors are logged by Watch, and it returns the errors of a Watch aborted by the policies.
+func (w *Watcher) Start(d time.Duration) error {
+	return w.StartContext(context.Background(), d)
+}
+
+// StartContext is Start which also stops on the cancellation of ctx and returns ctx.Err()
+func (w *Watcher) StartContext(ctx context.Context, d time.Duration) error {
+	if d < time.Nanosecond {
+		return ErrDurationTooShort
+	}
+
+	w.mu.Lock()
+	if w.running {
+		w.mu.Unlock()
+		return ErrWatcherRunning
+	}
+	w.running = true
+	ctx, cancel := context.WithCancel(ctx)
+	closing, stopped := ctx.Done(), make(chan struct{})
+	w.cancel, w.stopped = cancel, stopped
+	w.mu.Unlock()
+
+	defer func() {
+		w.mu.Lock()
+		w.running = false
+		w.cancel, w.stopped = nil, nil
+		w.mu.Unlock()
+		cancel()
+		close(stopped)
+	}()
+
+	ticker := time.NewTicker(d)
+	defer ticker.Stop()
+	for {
+		if err := w.WatchContext(ctx); ctx.Err() == nil && w.adapter.aborted(err) {
+			return err
+		}
 
-		// save the current file list to db if there are created, updated, deleted files
-		if len(deleted) > 0 || len(created) > 0 || len(updated) > 0 || len(moved) > 0 || len(renamed) > 0 {
-			w.adapter.Save(rootPath, infos)
+		select {
+		case <-closing:
+			// it's nil if it's closed by Close rather than the parent context
+			w.mu.Lock()
+			closed := w.cancel == nil
+			w.mu.Unlock()
+			if closed {
+				return nil
+			}
+			return ctx.Err()
+		case <-ticker.C:
 		}
 	}
+}
 
-	return nil
+// Close stops the polling of Start, the running Watch is interrupted, it returns after the polling is stopped
+func (w *Watcher) Close() {
+	w.mu.Lock()
+	cancel, stopped := w.cancel, w.stopped
+	w.cancel = nil
+	w.mu.Unlock()
+
+	if cancel != nil {
+		cancel()
+		<-stopped
+	}
 }
 
-func (w *Watcher) listFileInfos(rootPath string, option WatchOption) (FileInfos, error) {
-	var fileInfos = NewFileInfos()
+// scanRoot walks the root path and merges it with the saved file list while walking, then emits the events of
+// the changes and saves them. It returns true if watching should be aborted by the policies or ctx.
+func (w *Watcher) scanRoot(ctx context.Context, rootPath string, option WatchOption, errs *error) bool {
+	progress := w.adapter.progressReporter()
+	progress.ScanStarted(rootPath)
+	before := len(multierr.Errors(*errs))
+	defer func() {
+		// the errors of the root path, or the error of the canceled context
+		err := ctx.Err()
+		if err == nil {
+			err = multierr.Combine(multierr.Errors(*errs)[before:]...)
+		}
+		progress.ScanFinished(rootPath, err)
+	}()
 
-	var addFile = func(path string, info os.FileInfo) error {
-		isHidden, err := isHiddenFile(rootPath)
-		if err != nil {
-			return err
+	sc, err := w.adapter.beginScan(rootPath)
+	if err != nil {
+		return w.adapter.appendError(errs, newError(ErrorClassDB, rootPath, w.adapter.getDbPath(rootPath), "open db", err))
+	}
+	defer sc.close()
+
+	log.Printf("mapping files of \"%s\"...", rootPath)
+	if err = w.walk(ctx, sc, rootPath, option); err != nil {
+		if ctx.Err() != nil {
+			log.Printf("[WARN] the scan of \"%s\" is interrupted: %s", rootPath, ctx.Err())
+			return true
+		}
+		// the root path is skipped, it would be reported as all files deleted with an incomplete file list
+		log.Printf("[WARN] the scan of \"%s\" is incomplete, it will be resumed by the next scan", rootPath)
+		return w.adapter.appendError(errs, newError(ErrorClassWalk, rootPath, errorPath(err, rootPath), "walk", err))
+	}
+
+	log.Printf("mapping files of \"%s\" done, "+
+		"total size: %s, "+
+		"files: %d, "+
+		"directories: %d, "+
+		"symlinks: %d, "+
+		"filtered: %d",
+		rootPath,
+		ByteCountIEC(sc.stats.TotalSize),
+		sc.stats.FileCount,
+		sc.stats.DirCount,
+		sc.stats.LinkCount,
+		sc.stats.Filtered)
+
+	log.Printf("comparing: %s", rootPath)
+	created, updated, deleted := sc.created, sc.updated, sc.deleted
+	// hashing the created, updated
+	err = w.adapter.hashing(ctx, rootPath, NewFileInfos().Append(created, updated))
+	if ctx.Err() != nil {
+		log.Printf("[WARN] the hashing of \"%s\" is interrupted: %s", rootPath, ctx.Err())
+		return true
+	}
+	*errs = multierr.Append(*errs, err)
+	if w.adapter.aborted(err) {
+		return true
+	}
+
+	// it'll remove the moved or renamed files from deleted && created files
+	moved, renamed := w.adapter.compareMv(deleted, created)
+	log.Printf("created: %d, updated: %d, chmod: %d, deleted: %d, moved: %d, renamed: %d of \"%s\"", len(created), len(updated), len(sc.chmod), len(deleted), len(moved), len(renamed), rootPath)
+
+	// the deletion guard, the moved files are not counted
+	if guardErr := w.guardDeletion(sc, option, len(deleted)); guardErr != nil {
+		return w.adapter.appendError(errs, guardErr)
+	}
+
+	// append the events to the journal before saving, a crash between them replays the events rather than loses them
+	if ctx.Err() != nil {
+		return true
+	}
+	events := buildEvents(option.Op, created, updated, sc.chmod, deleted, moved, renamed)
+	if err = w.adapter.AppendEvents(rootPath, events...); err != nil {
+		if w.adapter.appendError(errs, newError(ErrorClassDB, rootPath, w.adapter.dataDBPath(journalDbFile), "append journal", err)) {
+			return true
 		}
+	}
+	w.emit(events...)
 
-		var ignored bool
-		if relPath, _ := filepath.Rel(rootPath, path); relPath != "" {
-			ignored = option.Ignore.MatchesPath(relPath)
+	// save the changes to db, every scan is recorded as a snapshot
+	if err = sc.commit(ctx, created, updated, deleted, moved, renamed); err != nil {
+		if ctx.Err() != nil {
+			log.Printf("[WARN] the saving of \"%s\" is interrupted, its events will be emitted again: %s", rootPath, ctx.Err())
+			return true
 		}
+		*errs = multierr.Append(*errs, err)
+		return w.adapter.aborted(err)
+	}
+	return false
+}
 
-		// Ignore hidden files and directories if the option is set
-		// or filter by the ignore pattern
-		// return filepath.SkipDir
-		if ignored || (option.IgnoreHidden && isHidden) {
-			if info.IsDir() {
-				return filepath.SkipDir
-			}
-			return nil
+// errorPath returns the path in the error, or the default path
+func errorPath(err error, defaultPath string) string {
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Path
+	}
+	return defaultPath
+}
+
+// summarizeErrors returns the count of the errors by their classes
+func summarizeErrors(err error) string {
+	errs := multierr.Errors(err)
+	counts := make(map[string]int)
+	for _, e := range errs {
+		var watchErr *Error
+		if errors.As(e, &watchErr) {
+			counts[watchErr.Class.String()]++
+		} else {
+			counts["other"]++
 		}
+	}
 
-		fileInfos.Put(path, convertToFileInfo(path, info))
+	var classes []string
+	for class, count := range counts {
+		classes = append(classes, fmt.Sprintf("%s: %d", class, count))
+	}
+	sort.Strings(classes)
+	return fmt.Sprintf("%d errors (%s)", len(errs), strings.Join(classes, ", "))
+}
 
-		return nil
+func (w *Watcher) emit(events ...Event) {
+	w.mu.Lock()
+	handlers := w.handlers
+	w.mu.Unlock()
+
+	for _, event := range events {
+		for _, handler := range handlers {
+			handler(event)
+		}
 	}
+}
 
-	if option.Recursive {
-		if err := filepath.Walk(rootPath, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			return addFile(path, info)
-		}); err != nil {
-			return nil, err
+// buildEvents converts the changes to events which are filtered by op, all ops are emitted if op is 0
+func buildEvents(op Op, created, updated, chmod, deleted, moved, renamed FileInfos) []Event {
+	var events []Event
+	var appendEvents = func(eventOp Op, infos FileInfos, mv bool) {
+		if op != 0 && op&eventOp == 0 {
+			return
 		}
-	} else {
-		dirs, err := os.ReadDir(rootPath)
-		if err != nil {
-			return nil, err
-		}
-		for _, dir := range dirs {
-			info, err := dir.Info()
-			if err != nil {
-				return nil, err
-			}
-			if err = addFile(filepath.Join(rootPath, dir.Name()), info); err != nil {
-				if errors.Is(err, filepath.SkipDir) {
-					continue
-				}
-				return nil, err
+		for _, path := range infos.SortedKeys() {
+			info := infos[path]
+			event := Event{Op: eventOp, Path: info.Path(), FileInfo: info}
+			// the key of moved/renamed is the old path
+			if mv {
+				event.OldPath = path
 			}
+			events = append(events, event)
 		}
 	}
 
-	// Remove the root path
-	fileInfos.Delete(rootPath)
-	return fileInfos, nil
+	appendEvents(Create, created, false)
+	appendEvents(Write, updated, false)
+	appendEvents(Chmod, chmod, false)
+	appendEvents(Remove, deleted, false)
+	appendEvents(Move, moved, true)
+	appendEvents(Rename, renamed, true)
+	return events
 }
@@ -0,0 +1,167 @@
+package watcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestStartErrors(t *testing.T) {
+	w := NewWatcher(NewAdapter("md5"))
+	if err := w.Start(0); !errors.Is(err, ErrDurationTooShort) {
+		t.Errorf("starting with 0: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- w.StartContext(ctx, time.Millisecond)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := w.Start(time.Millisecond); !errors.Is(err, ErrWatcherRunning) {
+		t.Errorf("starting the running watcher: %v", err)
+	}
+
+	// the parent context stops it with its error, Close stops it without error
+	cancel()
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: %v", err)
+	}
+	go func() {
+		done <- w.Start(time.Millisecond)
+	}()
+	time.Sleep(10 * time.Millisecond)
+	w.Close()
+	if err := <-done; err != nil {
+		t.Errorf("closed: %v", err)
+	}
+	w.Close()
+}
+
+// a root path added while watching is not loaded by LoadAll, it's scanned against its saved file list
+func TestAddSavedRoot(t *testing.T) {
+	rootPath := t.TempDir()
+	writeFile(t, filepath.Join(rootPath, "a.txt"), "a")
+	writeFile(t, filepath.Join(rootPath, "d", "b.txt"), "b")
+	watchOnce(t, NewAdapter("md5"), rootPath, testOption())
+
+	adapter := NewAdapter("md5")
+	w := NewWatcher(adapter)
+	watch := watchAgain(w)
+	if err := w.Add(rootPath, testOption()); err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range watch(t) {
+		t.Errorf("unexpected event of the saved root path: %s", event)
+	}
+
+	snapshots, err := adapter.Snapshots(rootPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 || snapshots[1].Initial {
+		t.Fatalf("the scan is recorded as %+v", snapshots[len(snapshots)-1])
+	}
+	if got := relNames(rootPath, savedFiles(t, adapter, rootPath)); fmt.Sprint(got) != "[a.txt d d/b.txt]" {
+		t.Errorf("saved %v", got)
+	}
+}
+
+// Add, Remove, WatchContext and StartContext are called concurrently, run with -race
+func TestConcurrentWatcher(t *testing.T) {
+	base := t.TempDir()
+	var rootPaths []string
+	for i := 0; i < 4; i++ {
+		rootPath := filepath.Join(base, fmt.Sprint("r", i))
+		for j := 0; j < 20; j++ {
+			writeFile(t, filepath.Join(rootPath, fmt.Sprint("d", j%3), fmt.Sprint("f", j)), fmt.Sprint(j))
+		}
+		rootPaths = append(rootPaths, rootPath)
+	}
+
+	adapter := NewAdapter("md5")
+	w := NewWatcher(adapter)
+	option := testOption()
+	option.Parallelism = 4
+	if err := w.Add(rootPaths[0], option); err != nil {
+		t.Fatal(err)
+	}
+
+	var events atomic.Int64
+	w.OnEvent(func(Event) {
+		events.Add(1)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	started := make(chan error)
+	go func() {
+		started <- w.StartContext(ctx, time.Millisecond)
+	}()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for k := 0; k < 30; k++ {
+				rootPath := rootPaths[(i+k)%len(rootPaths)]
+				switch k % 5 {
+				case 0:
+					if err := w.Add(rootPath, option); err != nil {
+						t.Error(err)
+					}
+				case 1:
+					w.Remove(rootPath)
+				case 2:
+					if err := w.WatchContext(ctx); err != nil {
+						t.Error(err)
+					}
+				case 3:
+					w.RootPaths()
+					adapter.ScanIncomplete(rootPath)
+					adapter.Suspect(rootPath)
+				case 4:
+					if err := w.Add(filepath.Join(rootPath, "d1", "*"), option); err != nil {
+						t.Error(err)
+					}
+					w.Remove(filepath.Join(rootPath, "d1", "*"))
+				}
+				if err := os.WriteFile(filepath.Join(rootPath, fmt.Sprint("n", i, "_", k)), []byte("n"), 0644); err != nil {
+					t.Error(err)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	cancel()
+	if err := <-started; !errors.Is(err, context.Canceled) {
+		t.Fatalf("the started watcher is not canceled: %v", err)
+	}
+	if events.Load() == 0 {
+		t.Error("no event is emitted")
+	}
+
+	// the file lists are consistent after all
+	for _, rootPath := range rootPaths {
+		if err := w.Add(rootPath, option); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Watch(); err != nil {
+		t.Fatal(err)
+	}
+	for _, rootPath := range rootPaths {
+		saved, walked := relNames(rootPath, savedFiles(t, adapter, rootPath)), relNames(rootPath, walkOnce(t, rootPath, testOption()))
+		if fmt.Sprint(saved) != fmt.Sprint(walked) {
+			t.Errorf("%s: saved %v, walked %v", rootPath, saved, walked)
+		}
+	}
+}